@@ -0,0 +1,176 @@
+// Package command executes the shell commands of the generators, e.g. the pre- and post-commands of the outputs. A
+// command is killed together with its child processes after a timeout and its output is captured for the error and
+// the log.
+package command
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeout is used for commands without a configured timeout
+	DefaultTimeout = time.Minute
+	// maxOutput is the number of bytes of stdout and stderr, which are kept for the error and the log
+	maxOutput = 16 * 1024
+	// waitDelay is the time for which the output of a killed command is read, before the pipes are closed
+	waitDelay = 5 * time.Second
+)
+
+var (
+	// executions counts the executed commands by exit code, commands which could not be started or were killed after
+	// the timeout are counted as "failed" and "timeout"
+	executions = expvar.NewMap("command_executions")
+	// durations sums up the durations of the executed commands in milliseconds by exit code
+	durations = expvar.NewMap("command_duration_ms")
+)
+
+// Result is the outcome of an executed command
+type Result struct {
+	Command  string
+	ExitCode int
+	Duration time.Duration
+	Stdout   string
+	Stderr   string
+	TimedOut bool
+}
+
+// Error is returned if a command could not be started, exited with another exit code than 0 or was killed after the
+// timeout. The error message contains the captured output of the command.
+type Error struct {
+	Result
+	Cause error
+}
+
+// Error returns the exit code or timeout and the captured output of the command
+func (err *Error) Error() string {
+	message := fmt.Sprintf("command \"%s\" failed with exit code %d after %s", err.Command, err.ExitCode, err.Duration)
+	if err.TimedOut {
+		message = fmt.Sprintf("command \"%s\" timed out and was killed after %s", err.Command, err.Duration)
+	} else if err.ExitCode < 0 {
+		message = fmt.Sprintf("command \"%s\" failed: %v", err.Command, err.Cause)
+	}
+
+	if output := err.output(); output != "" {
+		message += ": " + output
+	}
+	return message
+}
+
+func (err *Error) output() string {
+	var parts []string
+	if stderr := strings.TrimSpace(err.Stderr); stderr != "" {
+		parts = append(parts, "stderr: "+stderr)
+	}
+	if stdout := strings.TrimSpace(err.Stdout); stdout != "" {
+		parts = append(parts, "stdout: "+stdout)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Run executes the command with /bin/sh and the environment of the process extended by env. The command and all of
+// its child processes are killed, if it does not finish within the timeout. A timeout of zero or less uses the
+// DefaultTimeout.
+func Run(command string, timeout time.Duration, env ...string) (Result, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxOutput}
+	stderr := &limitedBuffer{limit: maxOutput}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = waitDelay
+	startProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	result := Result{
+		Command:  command,
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Duration: time.Since(start),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		TimedOut: err != nil && ctx.Err() == context.DeadlineExceeded,
+	}
+	record(result)
+
+	if err != nil {
+		if cmd.ProcessState == nil {
+			err = errors.Wrapf(err, "failed to execute command: \"%s\"", command)
+		}
+		return result, &Error{Result: result, Cause: err}
+	}
+	return result, nil
+}
+
+// record logs the result and updates the metrics
+func record(result Result) {
+	outcome := strconv.Itoa(result.ExitCode)
+	if result.TimedOut {
+		outcome = "timeout"
+	} else if result.ExitCode < 0 {
+		outcome = "failed"
+	}
+	executions.Add(outcome, 1)
+	durations.Add(outcome, result.Duration.Milliseconds())
+
+	switch outcome {
+	case "timeout":
+		log.Printf("command \"%s\" timed out after %s", result.Command, result.Duration)
+	case "failed":
+		log.Printf("command \"%s\" failed after %s", result.Command, result.Duration)
+	default:
+		log.Printf("command \"%s\" finished with exit code %d after %s", result.Command, result.ExitCode, result.Duration)
+	}
+	if stdout := strings.TrimSpace(result.Stdout); stdout != "" {
+		log.Printf("stdout of \"%s\": %s", result.Command, stdout)
+	}
+	if stderr := strings.TrimSpace(result.Stderr); stderr != "" {
+		log.Printf("stderr of \"%s\": %s", result.Command, stderr)
+	}
+}
+
+// limitedBuffer keeps the first bytes of the output, the remaining output is discarded
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	remaining := b.limit - b.buffer.Len()
+	if remaining < len(data) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buffer.Write(data[:remaining])
+		}
+		return len(data), nil
+	}
+	return b.buffer.Write(data)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buffer.String() + "... (truncated)"
+	}
+	return b.buffer.String()
+}
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("should capture output and exit code", func(t *testing.T) {
+		result, err := Run("echo out && echo err >&2", time.Second)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, result.ExitCode)
+		assert.Equal(t, "out\n", result.Stdout)
+		assert.Equal(t, "err\n", result.Stderr)
+		assert.False(t, result.TimedOut)
+		assert.Greater(t, result.Duration, time.Duration(0))
+	})
+
+	t.Run("should pass environment", func(t *testing.T) {
+		result, err := Run(`echo "$CES_CONFD_TEST"`, time.Second, "CES_CONFD_TEST=trillian")
+		require.NoError(t, err)
+		assert.Equal(t, "trillian\n", result.Stdout)
+	})
+
+	t.Run("should return output and exit code of failed command", func(t *testing.T) {
+		result, err := Run("echo 'nginx: [emerg] unknown directive' >&2; exit 3", time.Second)
+		require.Error(t, err)
+
+		assert.Equal(t, 3, result.ExitCode)
+		var commandErr *Error
+		require.ErrorAs(t, err, &commandErr)
+		assert.Equal(t, 3, commandErr.ExitCode)
+		assert.Contains(t, err.Error(), "failed with exit code 3")
+		assert.Contains(t, err.Error(), "stderr: nginx: [emerg] unknown directive")
+	})
+
+	t.Run("should kill command and its children after timeout", func(t *testing.T) {
+		marker := filepath.Join(t.TempDir(), "marker")
+
+		start := time.Now()
+		// the child keeps running in the background and would create the marker, if it was not killed
+		result, err := Run("(sleep 1 && touch "+marker+") & sleep 5", 200*time.Millisecond)
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 3*time.Second)
+
+		assert.True(t, result.TimedOut)
+		assert.Contains(t, err.Error(), "timed out")
+
+		time.Sleep(1500 * time.Millisecond)
+		_, statErr := os.Stat(marker)
+		assert.True(t, os.IsNotExist(statErr), "child process was not killed")
+	})
+
+	t.Run("should truncate long output", func(t *testing.T) {
+		result, err := Run("head -c 20000 /dev/zero | tr '\\0' 'a'", time.Second)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(result.Stdout, "... (truncated)"))
+		assert.Equal(t, maxOutput+len("... (truncated)"), len(result.Stdout))
+	})
+
+	t.Run("should count executions by exit code", func(t *testing.T) {
+		before := executions.Get("7")
+		count := int64(0)
+		if before != nil {
+			count = before.(interface{ Value() int64 }).Value()
+		}
+
+		_, err := Run("exit 7", time.Second)
+		require.Error(t, err)
+		assert.Equal(t, count+1, executions.Get("7").(interface{ Value() int64 }).Value())
+	})
+}
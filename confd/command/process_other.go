@@ -0,0 +1,14 @@
+//go:build !unix
+
+package command
+
+import "os/exec"
+
+// startProcessGroup is a no-op on systems without unix process groups
+func startProcessGroup(_ *exec.Cmd) {
+}
+
+// killProcessGroup kills only the command on systems without unix process groups
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
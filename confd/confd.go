@@ -40,6 +40,23 @@ func (data RawData) GetAttributeValue(key string) string {
 	return value
 }
 
+// GetAttributes returns all attributes from RawData with key 'attributes', which have a string value.
+// An empty map is returned if no value can be found for 'attributes'.
+func (data RawData) GetAttributes() map[string]string {
+	attributes := map[string]string{}
+	attributeMap, ok := data["attributes"].(map[string]interface{})
+	if !ok {
+		return attributes
+	}
+
+	for key, value := range attributeMap {
+		if stringValue, ok := value.(string); ok {
+			attributes[key] = stringValue
+		}
+	}
+	return attributes
+}
+
 // Order can be used to modify ordering via configuration
 type Order map[string]int
 
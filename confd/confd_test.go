@@ -69,3 +69,15 @@ func TestGetAttributeValue(t *testing.T) {
 		assert.Empty(t, attributeValue)
 	})
 }
+
+func TestGetAttributes(t *testing.T) {
+	t.Run("should return string attributes", func(t *testing.T) {
+		raw := confd.RawData{"attributes": map[string]interface{}{"location": "cas", "port": 8080.0}}
+		assert.Equal(t, map[string]string{"location": "cas"}, raw.GetAttributes())
+	})
+
+	t.Run("should return empty map without attributes", func(t *testing.T) {
+		assert.Empty(t, confd.RawData{}.GetAttributes())
+		assert.Empty(t, confd.RawData{"attributes": "cas"}.GetAttributes())
+	})
+}
@@ -0,0 +1,109 @@
+package confd
+
+import (
+	"expvar"
+	"time"
+)
+
+var (
+	// debounceEvents counts the events which triggered a rebuild by generator
+	debounceEvents = expvar.NewMap("debounce_events")
+	// debounceCoalesced counts the events which were merged into the rebuild of an earlier event by generator
+	debounceCoalesced = expvar.NewMap("debounce_coalesced")
+	// debounceRebuilds counts the rebuilds after the events were coalesced by generator
+	debounceRebuilds = expvar.NewMap("debounce_rebuilds")
+)
+
+// Debounce configures how bursts of events are coalesced into a single rebuild. The rebuild starts after no event
+// was received for the quiet period, but at the latest after max wait since the first event of the burst. A quiet
+// period of zero disables the debouncing and every event leads to a rebuild. If max wait is not configured, it is ten
+// times the quiet period.
+type Debounce struct {
+	QuietPeriod time.Duration `yaml:"quiet-period"`
+	MaxWait     time.Duration `yaml:"max-wait"`
+}
+
+func (config Debounce) withDefaults() Debounce {
+	if config.QuietPeriod < 0 {
+		config.QuietPeriod = 0
+	}
+	if config.MaxWait <= 0 {
+		config.MaxWait = config.QuietPeriod * 10
+	}
+	if config.MaxWait < config.QuietPeriod {
+		config.MaxWait = config.QuietPeriod
+	}
+	return config
+}
+
+// Debouncer coalesces the events of a generator. Trigger is called for every event and C fires when the rebuild is
+// due. A Debouncer is meant to be used from the select loop of a single goroutine.
+type Debouncer struct {
+	name    string
+	config  Debounce
+	timer   *time.Timer
+	pending int64
+	first   time.Time
+}
+
+// NewDebouncer creates a debouncer, the name identifies the generator in the metrics
+func NewDebouncer(name string, config Debounce) *Debouncer {
+	return &Debouncer{name: name, config: config.withDefaults()}
+}
+
+// Trigger registers an event and returns true, if the rebuild must be started immediately, because debouncing is
+// disabled. Otherwise the rebuild is signalled by C.
+func (debouncer *Debouncer) Trigger() bool {
+	debounceEvents.Add(debouncer.name, 1)
+	if debouncer.config.QuietPeriod == 0 {
+		debounceRebuilds.Add(debouncer.name, 1)
+		return true
+	}
+
+	now := time.Now()
+	if debouncer.pending == 0 {
+		debouncer.first = now
+	}
+	debouncer.pending++
+
+	delay := debouncer.config.QuietPeriod
+	if remaining := debouncer.first.Add(debouncer.config.MaxWait).Sub(now); remaining < delay {
+		delay = remaining
+	}
+
+	if debouncer.timer == nil {
+		debouncer.timer = time.NewTimer(delay)
+	} else {
+		debouncer.timer.Reset(delay)
+	}
+	return false
+}
+
+// C returns the channel which fires when the rebuild of the pending events is due. The channel is nil if no event is
+// pending, so that it blocks in a select.
+func (debouncer *Debouncer) C() <-chan time.Time {
+	if debouncer.pending == 0 {
+		return nil
+	}
+	return debouncer.timer.C
+}
+
+// Flush must be called after C has fired and before the rebuild. It resets the burst and returns the number of
+// coalesced events.
+func (debouncer *Debouncer) Flush() int64 {
+	pending := debouncer.pending
+	debouncer.pending = 0
+	if pending > 1 {
+		debounceCoalesced.Add(debouncer.name, pending-1)
+	}
+	debounceRebuilds.Add(debouncer.name, 1)
+	return pending
+}
+
+// Stop releases the timer of the debouncer, pending events are discarded
+func (debouncer *Debouncer) Stop() {
+	if debouncer.timer != nil {
+		debouncer.timer.Stop()
+	}
+	debouncer.pending = 0
+}
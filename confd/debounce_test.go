@@ -0,0 +1,128 @@
+package confd
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counters returns the events, coalesced events and rebuilds of the generator
+func counters(name string) [3]int64 {
+	var values [3]int64
+	for i, metrics := range []*expvar.Map{debounceEvents, debounceCoalesced, debounceRebuilds} {
+		if value, ok := metrics.Get(name).(*expvar.Int); ok {
+			values[i] = value.Value()
+		}
+	}
+	return values
+}
+
+// countersSince returns the increase of the counters since before
+func countersSince(name string, before [3]int64) [3]int64 {
+	after := counters(name)
+	return [3]int64{after[0] - before[0], after[1] - before[1], after[2] - before[2]}
+}
+
+func awaitRebuild(t *testing.T, debouncer *Debouncer) {
+	select {
+	case <-debouncer.C():
+	case <-time.After(5 * time.Second):
+		t.Fatal("debouncer did not fire")
+	}
+}
+
+func TestDebounce_withDefaults(t *testing.T) {
+	assert.Equal(t, Debounce{}, Debounce{}.withDefaults())
+	assert.Equal(t, Debounce{QuietPeriod: time.Second, MaxWait: 10 * time.Second}, Debounce{QuietPeriod: time.Second}.withDefaults())
+	assert.Equal(t, Debounce{QuietPeriod: time.Second, MaxWait: time.Second}, Debounce{QuietPeriod: time.Second, MaxWait: time.Millisecond}.withDefaults())
+	assert.Equal(t, Debounce{}, Debounce{QuietPeriod: -time.Second}.withDefaults())
+}
+
+func TestDebouncer(t *testing.T) {
+	t.Run("should rebuild immediately if debouncing is disabled", func(t *testing.T) {
+		before := counters(t.Name())
+		debouncer := NewDebouncer(t.Name(), Debounce{})
+		defer debouncer.Stop()
+
+		assert.True(t, debouncer.Trigger())
+		assert.True(t, debouncer.Trigger())
+		assert.Nil(t, debouncer.C())
+		assert.Equal(t, [3]int64{2, 0, 2}, countersSince(t.Name(), before))
+	})
+
+	t.Run("should block without pending events", func(t *testing.T) {
+		debouncer := NewDebouncer(t.Name(), Debounce{QuietPeriod: time.Millisecond})
+		defer debouncer.Stop()
+
+		assert.Nil(t, debouncer.C())
+	})
+
+	t.Run("should coalesce a burst into a single rebuild", func(t *testing.T) {
+		before := counters(t.Name())
+		debouncer := NewDebouncer(t.Name(), Debounce{QuietPeriod: 50 * time.Millisecond, MaxWait: 10 * time.Second})
+		defer debouncer.Stop()
+
+		for i := 0; i < 5; i++ {
+			assert.False(t, debouncer.Trigger())
+		}
+		awaitRebuild(t, debouncer)
+		assert.Equal(t, int64(5), debouncer.Flush())
+		assert.Nil(t, debouncer.C())
+		assert.Equal(t, [3]int64{5, 4, 1}, countersSince(t.Name(), before))
+	})
+
+	t.Run("should restart the quiet period with every event", func(t *testing.T) {
+		debouncer := NewDebouncer(t.Name(), Debounce{QuietPeriod: 100 * time.Millisecond, MaxWait: 10 * time.Second})
+		defer debouncer.Stop()
+
+		debouncer.Trigger()
+		time.Sleep(60 * time.Millisecond)
+		debouncer.Trigger()
+		select {
+		case <-debouncer.C():
+			t.Fatal("debouncer fired before the quiet period of the last event elapsed")
+		case <-time.After(60 * time.Millisecond):
+		}
+
+		awaitRebuild(t, debouncer)
+		assert.Equal(t, int64(2), debouncer.Flush())
+	})
+
+	t.Run("should rebuild after max wait during a continuous burst", func(t *testing.T) {
+		debouncer := NewDebouncer(t.Name(), Debounce{QuietPeriod: 100 * time.Millisecond, MaxWait: 300 * time.Millisecond})
+		defer debouncer.Stop()
+
+		start := time.Now()
+		fired := false
+		for !fired && time.Since(start) < 5*time.Second {
+			debouncer.Trigger()
+			select {
+			case <-debouncer.C():
+				fired = true
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		require.True(t, fired, "debouncer did not fire during a continuous burst")
+		assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+		assert.Less(t, time.Since(start), 2*time.Second)
+		assert.Greater(t, debouncer.Flush(), int64(1))
+	})
+
+	t.Run("should start a new burst after a flush", func(t *testing.T) {
+		before := counters(t.Name())
+		debouncer := NewDebouncer(t.Name(), Debounce{QuietPeriod: 20 * time.Millisecond})
+		defer debouncer.Stop()
+
+		debouncer.Trigger()
+		awaitRebuild(t, debouncer)
+		assert.Equal(t, int64(1), debouncer.Flush())
+
+		debouncer.Trigger()
+		awaitRebuild(t, debouncer)
+		assert.Equal(t, int64(1), debouncer.Flush())
+		assert.Equal(t, [3]int64{2, 0, 2}, countersSince(t.Name(), before))
+	})
+}
@@ -0,0 +1,142 @@
+// Package file writes the generated target files atomically, so that readers like nginx never see a partially written
+// file.
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMode is the mode of new target files
+const DefaultMode os.FileMode = 0644
+
+// Write renders the content into a temporary file in the directory of the target, syncs it to disk and renames it to
+// the target, so that readers see either the previous or the new content. An existing target keeps its mode and
+// owner, new targets are created with the given mode. If the target is a symlink, the file the link points to is
+// replaced. If the content cannot be rendered, the target is not touched.
+func Write(target string, mode os.FileMode, render func(writer io.Writer) error) error {
+	target, err := resolve(target)
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.Stat(target)
+	if err == nil {
+		mode = existing.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to stat target file %s", target)
+	}
+
+	dir := filepath.Dir(target)
+	// the temporary file is hidden and has no known extension, so that it is not picked up by includes of the target
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file for %s", target)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			temp.Close()
+			if err := os.Remove(temp.Name()); err != nil && !os.IsNotExist(err) {
+				log.Printf("failed to remove temporary file %s: %v", temp.Name(), err)
+			}
+		}
+	}()
+
+	err = render(temp)
+	if err != nil {
+		return err
+	}
+
+	err = temp.Chmod(mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to change mode of temporary file %s", temp.Name())
+	}
+
+	if existing != nil {
+		keepOwner(temp, existing)
+	}
+
+	err = temp.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "failed to sync temporary file %s", temp.Name())
+	}
+
+	err = temp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "failed to close temporary file %s", temp.Name())
+	}
+
+	err = os.Rename(temp.Name(), target)
+	if err != nil {
+		return errors.Wrapf(err, "failed to rename temporary file %s to %s", temp.Name(), target)
+	}
+	committed = true
+
+	syncDir(dir)
+	return nil
+}
+
+// WriteBytes writes the data atomically to the target, see Write
+func WriteBytes(target string, mode os.FileMode, data []byte) error {
+	return Write(target, mode, func(writer io.Writer) error {
+		_, err := writer.Write(data)
+		return err
+	})
+}
+
+// Unchanged returns true if the target exists and has exactly the given content. The content of the target is
+// compared by its sha256 hash, so that the target is not read into memory.
+func Unchanged(target string, content []byte) bool {
+	existing, err := os.Open(target)
+	if err != nil {
+		return false
+	}
+	defer existing.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, existing)
+	if err != nil {
+		log.Printf("failed to read target file %s, assume it has changed: %v", target, err)
+		return false
+	}
+
+	expected := sha256.Sum256(content)
+	return bytes.Equal(hash.Sum(nil), expected[:])
+}
+
+// resolve returns the file a symlink points to, other paths are returned unchanged
+func resolve(target string) (string, error) {
+	info, err := os.Lstat(target)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return target, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve symlink %s", target)
+	}
+	return resolved, nil
+}
+
+// syncDir persists the rename, a failure is only logged because the target was already replaced
+func syncDir(dir string) {
+	handle, err := os.Open(dir)
+	if err != nil {
+		log.Printf("failed to open directory %s to sync it: %v", dir, err)
+		return
+	}
+	defer handle.Close()
+
+	err = handle.Sync()
+	if err != nil {
+		log.Printf("failed to sync directory %s: %v", dir, err)
+	}
+}
@@ -0,0 +1,111 @@
+package file
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertNoTemporaryFiles(t *testing.T, dir string) {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, ".tmp", filepath.Ext(entry.Name()), "temporary file %s was not removed", entry.Name())
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("should create new target with mode", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "app.conf")
+
+		err := WriteBytes(target, 0640, []byte("server {}"))
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "server {}", string(content))
+
+		info, err := os.Stat(target)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		assertNoTemporaryFiles(t, dir)
+	})
+
+	t.Run("should replace existing target and keep its mode", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "app.conf")
+		require.NoError(t, os.WriteFile(target, []byte("old content which is longer"), 0600))
+		require.NoError(t, os.Chmod(target, 0600))
+
+		err := WriteBytes(target, DefaultMode, []byte("new"))
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+
+		info, err := os.Stat(target)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assertNoTemporaryFiles(t, dir)
+	})
+
+	t.Run("should keep existing target if rendering fails", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "app.conf")
+		require.NoError(t, os.WriteFile(target, []byte("old"), 0644))
+
+		err := Write(target, DefaultMode, func(writer io.Writer) error {
+			_, err := writer.Write([]byte("half of the"))
+			require.NoError(t, err)
+			return errors.New("failed to render template")
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to render template")
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		assertNoTemporaryFiles(t, dir)
+	})
+
+	t.Run("should replace file of symlink", func(t *testing.T) {
+		dir := t.TempDir()
+		actual := filepath.Join(dir, "actual.conf")
+		link := filepath.Join(dir, "app.conf")
+		require.NoError(t, os.WriteFile(actual, []byte("old"), 0644))
+		require.NoError(t, os.Symlink(actual, link))
+
+		err := WriteBytes(link, DefaultMode, []byte("new"))
+		require.NoError(t, err)
+
+		info, err := os.Lstat(link)
+		require.NoError(t, err)
+		assert.NotZero(t, info.Mode()&os.ModeSymlink, "symlink was replaced")
+
+		content, err := os.ReadFile(actual)
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+	})
+
+	t.Run("should fail if directory does not exist", func(t *testing.T) {
+		err := WriteBytes(filepath.Join(t.TempDir(), "missing", "app.conf"), DefaultMode, []byte("new"))
+		require.Error(t, err)
+	})
+}
+
+func TestUnchanged(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "menu.json")
+	assert.False(t, Unchanged(target, []byte("[]")), "missing target must be written")
+
+	require.NoError(t, os.WriteFile(target, []byte("[]"), 0644))
+	assert.True(t, Unchanged(target, []byte("[]")))
+	assert.False(t, Unchanged(target, []byte("[ ]")))
+	assert.False(t, Unchanged(target, []byte("")))
+}
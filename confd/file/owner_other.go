@@ -0,0 +1,9 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+// keepOwner is a no-op on systems without unix file owners
+func keepOwner(_ *os.File, _ os.FileInfo) {
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package file
+
+import (
+	"log"
+	"os"
+	"syscall"
+)
+
+// keepOwner transfers the owner of the existing target to the temporary file. Only privileged processes can change
+// the owner, a failure is logged and the file keeps the owner of the process.
+func keepOwner(temp *os.File, existing os.FileInfo) {
+	stat, ok := existing.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	info, err := temp.Stat()
+	if err != nil {
+		log.Printf("failed to stat temporary file %s: %v", temp.Name(), err)
+		return
+	}
+	if current, ok := info.Sys().(*syscall.Stat_t); ok && current.Uid == stat.Uid && current.Gid == stat.Gid {
+		return
+	}
+
+	err = temp.Chown(int(stat.Uid), int(stat.Gid))
+	if err != nil {
+		log.Printf("failed to keep owner %d:%d of %s: %v", stat.Uid, stat.Gid, existing.Name(), err)
+	}
+}
@@ -1,15 +1,18 @@
 package maintenance
 
 import (
+	"context"
 	"encoding/json"
-	"html/template"
 	"log"
-	"os"
-	"path"
+	"sync"
+	"time"
 
+	"github.com/cloudogu/ces-confd/confd"
+	"github.com/cloudogu/ces-confd/confd/output"
 	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/reload"
+	"github.com/cloudogu/ces-confd/confd/template"
 	"github.com/pkg/errors"
-	"go.etcd.io/etcd/client/v2"
 )
 
 // Source of maintenance path in etcd
@@ -29,39 +32,57 @@ func (p PageModel) String() string {
 
 // Configuration for the maintenance modul
 type Configuration struct {
-	Source   Source
-	Target   string
-	Template string
-	Default  PageModel
+	Source             Source
+	Target             string
+	Template           string
+	Default            PageModel
+	PreCommand         string        `yaml:"pre-command"`
+	PreCommandTimeout  time.Duration `yaml:"pre-command-timeout"`
+	PostCommand        string        `yaml:"post-command"`
+	PostCommandTimeout time.Duration `yaml:"post-command-timeout"`
+	QuarantineDir      string        `yaml:"quarantine-dir"`
+	Reload             reload.Reload
+	Debounce           confd.Debounce
+	// Outputs are rendered from the same page model in addition to the template and target above
+	Outputs []output.Output
 }
 
-func write(conf Configuration, pageModel PageModel) error {
-	name := path.Base(conf.Template)
-	tmpl, err := template.New(name).ParseFiles(conf.Template)
-	if err != nil {
-		return errors.Wrap(err, "failed to parse template")
-	}
-
-	file, err := os.Create(conf.Target)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create target file %s", conf.Target)
+// outputs returns the template and target of the configuration followed by the additional outputs. The maintenance
+// page is an html page, so templates are rendered with html/template, unless an output selects another engine.
+func (conf Configuration) outputs() []output.Output {
+	var outputs []output.Output
+	if conf.Target != "" {
+		outputs = append(outputs, output.Output{
+			Template:           conf.Template,
+			Target:             conf.Target,
+			PreCommand:         conf.PreCommand,
+			PreCommandTimeout:  conf.PreCommandTimeout,
+			PostCommand:        conf.PostCommand,
+			PostCommandTimeout: conf.PostCommandTimeout,
+			QuarantineDir:      conf.QuarantineDir,
+			Reload:             conf.Reload,
+		})
 	}
+	outputs = append(outputs, conf.Outputs...)
+	return output.WithDefaultEngine(outputs, template.EngineHTML)
+}
 
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			log.Println("failed to close file")
-		}
-	}()
+// Validate checks the outputs of the configuration
+func (conf Configuration) Validate() error {
+	return output.Validate(conf.outputs())
+}
 
-	err = tmpl.Execute(file, &pageModel)
-	if err != nil {
-		return errors.Wrap(err, "failed to render template")
+// write renders the outputs with the page model, the commands receive the value of the maintenance key, which is
+// empty for the default page
+func write(ctx context.Context, conf Configuration, registry confRegistry.Registry, pageModel PageModel, maintenance string) error {
+	env := []string{
+		output.Env(output.EnvGenerator, "maintenance"),
+		output.Env(output.EnvMaintenance, maintenance),
 	}
-	return nil
+	return output.WriteAll(conf.outputs(), &pageModel, template.Funcs(ctx, registry), env...)
 }
 
-func renderTemplate(conf Configuration, value string) error {
+func renderTemplate(ctx context.Context, conf Configuration, registry confRegistry.Registry, value string) error {
 	log.Println("render maintenance page:", value)
 
 	var pageModel PageModel
@@ -70,22 +91,25 @@ func renderTemplate(conf Configuration, value string) error {
 		return errors.Wrapf(err, "Could not parse JSON for maintenance page")
 	}
 
-	return write(conf, pageModel)
+	return write(ctx, conf, registry, pageModel, value)
 }
 
-func renderDefault(conf Configuration) {
+func renderDefault(ctx context.Context, conf Configuration, registry confRegistry.Registry) {
 	log.Println("render default maintenance page")
-	err := write(conf, conf.Default)
+	err := write(ctx, conf, registry, conf.Default, "")
 	if err != nil {
 		log.Printf("failed to write template with default: %v", err)
 	}
 }
 
-func readAndRender(conf Configuration, registry confRegistry.Registry) {
-	resp, err := registry.Get(conf.Source.Path)
+func readAndRender(ctx context.Context, conf Configuration, registry confRegistry.Registry) {
+	// the page and the keys, which are read by the template, are read from the same state of the registry
+	registry = confRegistry.Snapshot(registry)
+
+	node, err := registry.Get(ctx, conf.Source.Path)
 	if err != nil {
-		if client.IsKeyNotFound(err) {
-			renderDefault(conf)
+		if confRegistry.IsKeyNotFound(err) {
+			renderDefault(ctx, conf, registry)
 			return
 		}
 
@@ -93,26 +117,47 @@ func readAndRender(conf Configuration, registry confRegistry.Registry) {
 		return
 	}
 
-	err = renderTemplate(conf, resp.Node.Value)
+	err = renderTemplate(ctx, conf, registry, node.Value)
 	if err != nil {
-		log.Printf("failed to render template with model %s: %v", resp.Node.Value, err)
+		log.Printf("failed to render template with model %s: %v", node.Value, err)
 	}
 }
 
-// Run renders the maintenance page and watches for changes
-func Run(conf Configuration, registry confRegistry.Registry) {
+// Run renders the maintenance page and watches for changes. Run returns after the context is cancelled and the
+// watcher has finished its work.
+func Run(ctx context.Context, conf Configuration, registry confRegistry.Registry) {
+	output.CheckPreCommands(conf.outputs())
 
-	updateChannel := make(chan *client.Response)
+	updateChannel := make(chan *confRegistry.Event)
 
+	var watcher sync.WaitGroup
+	watcher.Add(1)
 	go func() {
-		for {
-			readAndRender(conf, registry)
-			registry.Watch(conf.Source.Path, false, updateChannel)
+		defer watcher.Done()
+		for ctx.Err() == nil {
+			readAndRender(ctx, conf, registry)
+			registry.Watch(ctx, conf.Source.Path, false, updateChannel)
 		}
 	}()
 
+	debouncer := confd.NewDebouncer("maintenance", conf.Debounce)
+	defer debouncer.Stop()
 	for {
-		<-updateChannel
-		readAndRender(conf, registry)
+		select {
+		case <-ctx.Done():
+			watcher.Wait()
+			log.Println("stopped maintenance watcher")
+			return
+		case event := <-updateChannel:
+			if event.IsResync() {
+				log.Printf("resync maintenance page, changes of %s may have been lost", event.Key())
+			}
+			if debouncer.Trigger() {
+				readAndRender(ctx, conf, registry)
+			}
+		case <-debouncer.C():
+			log.Printf("render maintenance page after %d changes", debouncer.Flush())
+			readAndRender(ctx, conf, registry)
+		}
 	}
 }
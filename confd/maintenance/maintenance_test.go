@@ -0,0 +1,66 @@
+package maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudogu/ces-confd/confd/output"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfiguration_outputs(t *testing.T) {
+	conf := Configuration{
+		Template:    "maintenance.tpl",
+		Target:      "maintenance.html",
+		PreCommand:  "tidy -q -e \"$CES_CONFD_CANDIDATE\"",
+		PostCommand: "/usr/local/bin/push-to-cdn.sh",
+		Outputs:     []output.Output{{Template: "maintenance.json.tpl", Target: "maintenance.json", TemplateEngine: template.EngineText}},
+	}
+
+	assert.Equal(t, []output.Output{
+		{
+			Template:       "maintenance.tpl",
+			Target:         "maintenance.html",
+			TemplateEngine: template.EngineHTML,
+			PreCommand:     "tidy -q -e \"$CES_CONFD_CANDIDATE\"",
+			PostCommand:    "/usr/local/bin/push-to-cdn.sh",
+		},
+		{Template: "maintenance.json.tpl", Target: "maintenance.json", TemplateEngine: template.EngineText},
+	}, conf.outputs())
+}
+
+func TestReadAndRender_hooks(t *testing.T) {
+	dir := t.TempDir()
+	tpl := filepath.Join(dir, "maintenance.tpl")
+	require.NoError(t, os.WriteFile(tpl, []byte("<h1>{{.Title}}</h1>"), 0644))
+	commands := filepath.Join(dir, "commands")
+
+	conf := Configuration{
+		Source:      Source{Path: "/config/_global/maintenance"},
+		Default:     PageModel{Title: "Maintenance"},
+		Template:    tpl,
+		Target:      filepath.Join(dir, "maintenance.html"),
+		PreCommand:  `grep -q "<h1>" "$CES_CONFD_CANDIDATE"`,
+		PostCommand: `echo "$CES_CONFD_GENERATOR:$CES_CONFD_MAINTENANCE" >> ` + commands,
+	}
+
+	registry := confRegistry.NewMemoryRegistry()
+	readAndRender(context.Background(), conf, registry)
+
+	value := `{"title": "Update", "text": "back soon"}`
+	require.NoError(t, registry.Set(conf.Source.Path, value))
+	readAndRender(context.Background(), conf, registry)
+
+	content, err := os.ReadFile(conf.Target)
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>Update</h1>", string(content))
+
+	content, err = os.ReadFile(commands)
+	require.NoError(t, err)
+	assert.Equal(t, "maintenance:\nmaintenance:"+value+"\n", string(content))
+}
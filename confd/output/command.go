@@ -0,0 +1,177 @@
+package output
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudogu/ces-confd/confd/command"
+	"github.com/cloudogu/ces-confd/confd/file"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EnvCandidate is the environment variable with the path of the rendered candidate file, which is checked by the
+	// pre-command, e.g. nginx -t -c "$CES_CONFD_CANDIDATE"
+	EnvCandidate = "CES_CONFD_CANDIDATE"
+	// EnvTarget is the environment variable with the path of the target
+	EnvTarget = "CES_CONFD_TARGET"
+	// EnvGenerator is the environment variable with the name of the generator, e.g. service, maintenance or warp
+	EnvGenerator = "CES_CONFD_GENERATOR"
+	// EnvMaintenance is the environment variable with the value of the maintenance mode key, it is empty if the
+	// maintenance mode is not active
+	EnvMaintenance = "CES_CONFD_MAINTENANCE"
+)
+
+// CheckPreCommands logs a warning for every pre-command, which does not reference CES_CONFD_CANDIDATE. Such a command,
+// e.g. nginx -t, is executed like in older releases: the rendered content replaces the target during the check, so
+// that the live target is broken for the duration of the command, if the check fails.
+func CheckPreCommands(outputs []Output) {
+	for _, output := range outputs {
+		if output.PreCommand != "" && !checksCandidate(output.PreCommand) {
+			log.Printf("warning: pre-command \"%s\" of %s does not reference $%s, the rendered content replaces the target during the check",
+				output.PreCommand, output.Target, EnvCandidate)
+		}
+	}
+}
+
+// checksCandidate returns true, if the pre-command reads the path of the candidate from CES_CONFD_CANDIDATE
+func checksCandidate(preCommand string) bool {
+	return strings.Contains(preCommand, EnvCandidate)
+}
+
+// Env returns the environment variable in the form name=value
+func Env(name string, value string) string {
+	return name + "=" + value
+}
+
+// post executes the post-command and sends the reload signal afterwards, if they are configured
+func post(conf Output, env []string) error {
+	if conf.PostCommand != "" {
+		log.Println("execute post command", conf.PostCommand)
+		env = append([]string{Env(EnvTarget, conf.Target)}, env...)
+		_, err := command.Run(conf.PostCommand, conf.PostCommandTimeout, env...)
+		if err != nil {
+			return errors.Wrap(err, "failed to execute post command")
+		}
+	}
+
+	if conf.Reload.Enabled() {
+		err := conf.Reload.Send()
+		if err != nil {
+			return errors.Wrap(err, "failed to reload")
+		}
+	}
+	return nil
+}
+
+// preCheck writes the content to a candidate file next to the target and executes the pre-command, which finds the
+// path of the candidate in the environment variable CES_CONFD_CANDIDATE. The target is not touched and the candidate
+// is removed after the check. Pre-commands, which do not reference the candidate, check the target in place.
+func preCheck(conf Output, content []byte, env []string) error {
+	if !checksCandidate(conf.PreCommand) {
+		return inPlaceCheck(conf, content, env)
+	}
+
+	candidate, err := writeCandidate(conf.Target, content)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(candidate); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove candidate %s: %v", candidate, err)
+		}
+	}()
+
+	log.Printf("execute pre command %s with candidate %s", conf.PreCommand, candidate)
+	env = append([]string{Env(EnvCandidate, candidate), Env(EnvTarget, conf.Target)}, env...)
+	_, err = command.Run(conf.PreCommand, conf.PreCommandTimeout, env...)
+	if err != nil {
+		return errors.Wrap(err, "pre check command failed")
+	}
+	return nil
+}
+
+// writeCandidate writes the content to a hidden file in the directory of the target, so that relative includes of
+// the candidate resolve like those of the target. The file has no known extension, so that it is not picked up by
+// includes of the target directory.
+func writeCandidate(target string, content []byte) (string, error) {
+	candidate, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.candidate")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create candidate for %s", target)
+	}
+
+	_, err = candidate.Write(content)
+	if closeErr := candidate.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(candidate.Name())
+		return "", errors.Wrapf(err, "failed to write candidate %s", candidate.Name())
+	}
+	return candidate.Name(), nil
+}
+
+// inPlaceCheck moves the target aside, writes the content to the target and executes the pre-command, e.g. nginx -t,
+// which reads the target itself. The previous target is moved back after the check, a new target is removed.
+func inPlaceCheck(conf Output, content []byte, env []string) error {
+	previous, err := moveAside(conf.Target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := moveBack(previous, conf.Target); err != nil {
+			log.Printf("failed to restore %s after pre check: %v", conf.Target, err)
+		}
+	}()
+
+	err = file.WriteBytes(conf.Target, conf.mode(), content)
+	if err != nil {
+		return errors.Wrap(err, "failed to write target for pre check")
+	}
+
+	log.Printf("execute pre command %s with rendered target %s", conf.PreCommand, conf.Target)
+	env = append([]string{Env(EnvTarget, conf.Target)}, env...)
+	_, err = command.Run(conf.PreCommand, conf.PreCommandTimeout, env...)
+	if err != nil {
+		return errors.Wrap(err, "pre check command failed")
+	}
+	return nil
+}
+
+// moveAside renames the target to a hidden file in its directory and returns the name of the file or an empty string,
+// if the target does not exist
+func moveAside(target string) (string, error) {
+	_, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "failed to stat target %s", target)
+	}
+
+	aside, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.previous")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to move %s aside", target)
+	}
+	aside.Close()
+
+	err = os.Rename(target, aside.Name())
+	if err != nil {
+		os.Remove(aside.Name())
+		return "", errors.Wrapf(err, "failed to move %s aside", target)
+	}
+	return aside.Name(), nil
+}
+
+// moveBack renames the previous target back to the target or removes the target, if there was no previous target
+func moveBack(previous string, target string) error {
+	if previous == "" {
+		err := os.Remove(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.Rename(previous, target)
+}
@@ -0,0 +1,228 @@
+package output
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertNoCandidates(t *testing.T, directory string) {
+	entries, err := os.ReadDir(directory)
+	require.Nil(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, ".candidate", filepath.Ext(entry.Name()), "candidate %s was not removed", entry.Name())
+	}
+}
+
+func TestPreCheck(t *testing.T) {
+	directory := t.TempDir()
+
+	target := path.Join(directory, "target")
+	err := os.WriteFile(target, []byte("trillian"), 0644)
+	require.Nil(t, err)
+
+	conf := Output{
+		Target:     target,
+		PreCommand: `grep slarti "$CES_CONFD_CANDIDATE"`,
+	}
+
+	err = preCheck(conf, []byte("slarti"), nil)
+	require.Nil(t, err)
+
+	content, err := os.ReadFile(target)
+	require.Nil(t, err)
+
+	require.Equal(t, "trillian", string(content))
+	assertNoCandidates(t, directory)
+}
+
+func TestPreCheckFailed(t *testing.T) {
+	directory := t.TempDir()
+
+	target := path.Join(directory, "target")
+	err := os.WriteFile(target, []byte("trillian"), 0644)
+	require.Nil(t, err)
+
+	// grep trillian should fail, because the candidate contains slarti
+
+	conf := Output{
+		Target:     target,
+		PreCommand: `grep trillian "$CES_CONFD_CANDIDATE"`,
+	}
+
+	err = preCheck(conf, []byte("slarti"), nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed with exit code 1")
+
+	content, err := os.ReadFile(target)
+	require.Nil(t, err)
+
+	require.Equal(t, "trillian", string(content))
+	assertNoCandidates(t, directory)
+}
+
+func TestPreCheckDoesNotTouchTarget(t *testing.T) {
+	directory := t.TempDir()
+
+	target := path.Join(directory, "target")
+	err := os.WriteFile(target, []byte("trillian"), 0644)
+	require.Nil(t, err)
+
+	// the live target keeps its content while the candidate is checked
+	conf := Output{
+		Target:     target,
+		PreCommand: `grep trillian "$CES_CONFD_TARGET" && grep slarti "$CES_CONFD_CANDIDATE" && test "$(dirname "$CES_CONFD_CANDIDATE")" = "$(dirname "$CES_CONFD_TARGET")"`,
+	}
+
+	err = preCheck(conf, []byte("slarti"), nil)
+	require.Nil(t, err)
+}
+
+func TestPreCheckWithoutExistingConfiguration(t *testing.T) {
+	directory := t.TempDir()
+
+	target := path.Join(directory, "target")
+
+	conf := Output{
+		Target:     target,
+		PreCommand: `grep trillian "$CES_CONFD_CANDIDATE"`,
+	}
+
+	err := preCheck(conf, []byte("slarti"), nil)
+	require.NotNil(t, err)
+
+	// be sure target does not exists
+	_, err = os.Stat(target)
+	require.True(t, os.IsNotExist(err))
+	assertNoCandidates(t, directory)
+}
+
+func TestPreCheckWithMissingDirectory(t *testing.T) {
+	conf := Output{
+		Target:     path.Join(t.TempDir(), "missing", "target"),
+		PreCommand: `test -f "$CES_CONFD_CANDIDATE"`,
+	}
+
+	err := preCheck(conf, []byte("slarti"), nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to create candidate")
+}
+
+func TestPreCheckEnvironment(t *testing.T) {
+	directory := t.TempDir()
+	target := path.Join(directory, "target")
+
+	conf := Output{
+		Target:     target,
+		PreCommand: `test "$CES_CONFD_TARGET" = "` + target + `" && test "$CES_CONFD_GENERATOR" = "service" && test -f "$CES_CONFD_CANDIDATE"`,
+	}
+
+	err := preCheck(conf, []byte("slarti"), []string{Env(EnvGenerator, "service")})
+	require.Nil(t, err)
+}
+
+func TestPreCheckInPlace(t *testing.T) {
+	t.Run("should reject bad candidate with pre-command which checks the target", func(t *testing.T) {
+		directory := t.TempDir()
+		target := path.Join(directory, "target")
+		err := os.WriteFile(target, []byte("trillian"), 0644)
+		require.Nil(t, err)
+
+		conf := Output{
+			Target:     target,
+			PreCommand: "grep trillian " + target,
+		}
+
+		err = preCheck(conf, []byte("slarti"), nil)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "failed with exit code 1")
+
+		content, err := os.ReadFile(target)
+		require.Nil(t, err)
+		assert.Equal(t, "trillian", string(content))
+		assertNoPrevious(t, directory)
+	})
+	t.Run("should accept good candidate with pre-command which checks the target", func(t *testing.T) {
+		directory := t.TempDir()
+		target := path.Join(directory, "target")
+		err := os.WriteFile(target, []byte("trillian"), 0644)
+		require.Nil(t, err)
+
+		conf := Output{
+			Target:     target,
+			PreCommand: "grep slarti " + target,
+		}
+
+		err = preCheck(conf, []byte("slarti"), nil)
+		require.Nil(t, err)
+
+		content, err := os.ReadFile(target)
+		require.Nil(t, err)
+		assert.Equal(t, "trillian", string(content))
+		assertNoPrevious(t, directory)
+	})
+	t.Run("should remove new target after the check", func(t *testing.T) {
+		directory := t.TempDir()
+		target := path.Join(directory, "target")
+
+		conf := Output{
+			Target:     target,
+			PreCommand: "grep trillian " + target,
+		}
+
+		err := preCheck(conf, []byte("slarti"), nil)
+		require.NotNil(t, err)
+
+		_, err = os.Stat(target)
+		assert.True(t, os.IsNotExist(err))
+	})
+	t.Run("should not deploy candidate which fails the pre-command", func(t *testing.T) {
+		directory := t.TempDir()
+		target := path.Join(directory, "target")
+		err := os.WriteFile(target, []byte("server { listen 80; }"), 0644)
+		require.Nil(t, err)
+
+		conf := Output{
+			Target:      target,
+			PreCommand:  "grep -q listen " + target,
+			PostCommand: "true",
+		}
+
+		err = conf.Deploy([]byte("server { broken"))
+		require.NotNil(t, err)
+
+		content, err := os.ReadFile(target)
+		require.Nil(t, err)
+		assert.Equal(t, "server { listen 80; }", string(content))
+	})
+}
+
+func assertNoPrevious(t *testing.T, directory string) {
+	entries, err := os.ReadDir(directory)
+	require.Nil(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, ".previous", filepath.Ext(entry.Name()), "previous target %s was not moved back", entry.Name())
+	}
+}
+
+func TestCheckPreCommands(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	CheckPreCommands([]Output{
+		{Target: "/etc/nginx/conf.d/app.conf", PreCommand: "nginx -t"},
+		{Target: "/etc/nginx/conf.d/upstreams.conf", PreCommand: `check-nginx-include.sh "$CES_CONFD_CANDIDATE"`},
+		{Target: "/var/www/html/services.json"},
+	})
+
+	assert.Contains(t, logs.String(), `warning: pre-command "nginx -t" of /etc/nginx/conf.d/app.conf does not reference $CES_CONFD_CANDIDATE, the rendered content replaces the target`)
+	assert.NotContains(t, logs.String(), "upstreams.conf")
+	assert.NotContains(t, logs.String(), "services.json")
+}
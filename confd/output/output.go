@@ -0,0 +1,172 @@
+// Package output renders the templates of a generator to their targets and executes the pre- and post-commands of
+// each target.
+package output
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudogu/ces-confd/confd/file"
+	"github.com/cloudogu/ces-confd/confd/reload"
+	"github.com/cloudogu/ces-confd/confd/template"
+	"github.com/pkg/errors"
+)
+
+// Output is a template, which is rendered to a target file. The pre-command checks the rendered target before it is
+// replaced and the post-command is executed after the target was replaced, e.g. to reload nginx. Instead of or in
+// addition to the post-command, the reload sends a signal to the process of a pid file. Commands are killed after
+// their timeout, which defaults to command.DefaultTimeout. If the post-command or the reload fails, the
+// last-known-good target is restored and both are executed again. Rejected candidates are recorded in the quarantine
+// directory, if one is configured.
+type Output struct {
+	Template           string
+	Target             string
+	TemplateEngine     template.Engine `yaml:"template-engine"`
+	PreCommand         string          `yaml:"pre-command"`
+	PreCommandTimeout  time.Duration   `yaml:"pre-command-timeout"`
+	PostCommand        string          `yaml:"post-command"`
+	PostCommandTimeout time.Duration   `yaml:"post-command-timeout"`
+	QuarantineDir      string          `yaml:"quarantine-dir"`
+	Reload             reload.Reload
+	// Mode of a new target, defaults to file.DefaultMode
+	Mode os.FileMode `yaml:"-"`
+}
+
+// WithDefaultEngine returns the outputs, outputs without template engine use the given engine
+func WithDefaultEngine(outputs []Output, engine template.Engine) []Output {
+	result := make([]Output, len(outputs))
+	for i, output := range outputs {
+		if output.TemplateEngine == "" {
+			output.TemplateEngine = engine
+		}
+		result[i] = output
+	}
+	return result
+}
+
+// Validate checks the outputs, when the configuration is loaded
+func Validate(outputs []Output) error {
+	for _, output := range outputs {
+		err := output.Reload.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "invalid reload of %s", output.Target)
+		}
+	}
+	return nil
+}
+
+// Render executes the template of the output with the data
+func (output Output) Render(data interface{}, funcs template.FuncMap) ([]byte, error) {
+	return template.Render(output.TemplateEngine, output.Template, data, funcs)
+}
+
+// Write renders the template with the data and deploys the rendered content to the target
+func (output Output) Write(data interface{}, funcs template.FuncMap, env ...string) error {
+	content, err := output.Render(data, funcs)
+	if err != nil {
+		return err
+	}
+	return output.Deploy(content, env...)
+}
+
+// Deploy checks the content with the pre-command, writes it to the target and executes the post-command. If the
+// content equals the current target, the write and the pre- and post-commands are skipped, unless the post-command or
+// reload of the previous deployment failed. The environment variables are passed to the commands in addition to the
+// target and candidate.
+func (output Output) Deploy(content []byte, env ...string) error {
+	if file.Unchanged(output.Target, content) {
+		if !hasFailed(output.Target) {
+			log.Printf("configuration %s is unchanged, skip write and commands", output.Target)
+			return nil
+		}
+		log.Printf("configuration %s is unchanged, but its previous post command failed, execute commands again", output.Target)
+	}
+
+	if output.PreCommand != "" {
+		err := preCheck(output, content, env)
+		if err != nil {
+			quarantine(output.QuarantineDir, output.Target, content)
+			return errors.Wrap(err, "pre check failed")
+		}
+	}
+
+	if output.hasPost() {
+		err := keepLastKnownGood(output.Target)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := file.WriteBytes(output.Target, output.mode(), content)
+	if err != nil {
+		return errors.Wrap(err, "failed to write data")
+	}
+
+	if output.hasPost() {
+		err = post(output, env)
+		if err != nil {
+			quarantine(output.QuarantineDir, output.Target, content)
+			markFailed(output.Target)
+			return output.rollback(err, env)
+		}
+
+		err = saveLastKnownGood(output.Target, content)
+		if err != nil {
+			log.Printf("target %s was deployed, but: %v", output.Target, err)
+		}
+		clearFailed(output.Target)
+	}
+	return nil
+}
+
+func (output Output) mode() os.FileMode {
+	if output.Mode == 0 {
+		return file.DefaultMode
+	}
+	return output.Mode
+}
+
+// hasPost returns true, if a post-command or a reload is configured
+func (output Output) hasPost() bool {
+	return output.PostCommand != "" || output.Reload.Enabled()
+}
+
+// rollback restores the last-known-good target after the post-command or reload failed and executes both again
+func (output Output) rollback(cause error, env []string) error {
+	cause = errors.Wrap(cause, "post command failed")
+
+	restored, err := restoreLastKnownGood(output.Target)
+	if err != nil {
+		return errors.Wrapf(cause, "rollback failed: %v", err)
+	}
+	if !restored {
+		return errors.Wrapf(cause, "no last-known-good of %s to roll back to", output.Target)
+	}
+
+	err = post(output, env)
+	if err != nil {
+		return errors.Wrapf(cause, "restored last-known-good, but post command failed again: %v", err)
+	}
+	return errors.Wrap(cause, "restored last-known-good")
+}
+
+// WriteAll writes all outputs with the same data, functions and environment variables. A failed output does not stop
+// the others, the returned error contains the failures of all outputs.
+func WriteAll(outputs []Output, data interface{}, funcs template.FuncMap, env ...string) error {
+	var failures []string
+	for _, output := range outputs {
+		err := output.Write(data, funcs, env...)
+		if err != nil {
+			log.Printf("failed to write %s: %v", output.Target, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", output.Target, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.Errorf("failed to write %d of %d outputs: %s", len(failures), len(outputs), strings.Join(failures, "; "))
+	}
+	return nil
+}
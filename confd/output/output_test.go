@@ -0,0 +1,133 @@
+package output
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudogu/ces-confd/confd/reload"
+	"github.com/cloudogu/ces-confd/confd/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type model struct {
+	Names []string
+}
+
+func writeFile(t *testing.T, file string, content string) string {
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func readFile(t *testing.T, file string) string {
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestWithDefaultEngine(t *testing.T) {
+	outputs := []Output{{Target: "app.conf"}, {Target: "index.html", TemplateEngine: template.EngineHTML}}
+
+	result := WithDefaultEngine(outputs, template.EngineText)
+	assert.Equal(t, []Output{
+		{Target: "app.conf", TemplateEngine: template.EngineText},
+		{Target: "index.html", TemplateEngine: template.EngineHTML},
+	}, result)
+	assert.Empty(t, outputs[0].TemplateEngine, "the outputs must not be modified")
+}
+
+func TestWriteAll(t *testing.T) {
+	funcs := template.Funcs(context.Background(), nil)
+
+	t.Run("should write all outputs from the same data", func(t *testing.T) {
+		dir := t.TempDir()
+		commands := filepath.Join(dir, "commands")
+		outputs := WithDefaultEngine([]Output{
+			{
+				Template:    writeFile(t, filepath.Join(dir, "app.conf.tpl"), "{{range .Names}}location /{{.}};{{end}}"),
+				Target:      filepath.Join(dir, "app.conf"),
+				PreCommand:  "echo pre app >> " + commands,
+				PostCommand: "echo post app >> " + commands,
+			},
+			{
+				Template:    writeFile(t, filepath.Join(dir, "inventory.tpl"), "{{toJson .Names}}"),
+				Target:      filepath.Join(dir, "inventory.json"),
+				PostCommand: "echo post inventory >> " + commands,
+			},
+		}, template.EngineText)
+
+		err := WriteAll(outputs, model{Names: []string{"cas", "nginx"}}, funcs)
+		require.NoError(t, err)
+
+		assert.Equal(t, "location /cas;location /nginx;", readFile(t, filepath.Join(dir, "app.conf")))
+		assert.Equal(t, `["cas","nginx"]`, readFile(t, filepath.Join(dir, "inventory.json")))
+		assert.Equal(t, "pre app\npost app\npost inventory\n", readFile(t, commands))
+	})
+
+	t.Run("should only execute commands of changed outputs", func(t *testing.T) {
+		dir := t.TempDir()
+		commands := filepath.Join(dir, "commands")
+		outputs := WithDefaultEngine([]Output{
+			{
+				Template:    writeFile(t, filepath.Join(dir, "app.conf.tpl"), "{{len .Names}}"),
+				Target:      filepath.Join(dir, "app.conf"),
+				PostCommand: "echo post app >> " + commands,
+			},
+			{
+				Template:    writeFile(t, filepath.Join(dir, "inventory.tpl"), "{{toJson .Names}}"),
+				Target:      filepath.Join(dir, "inventory.json"),
+				PostCommand: "echo post inventory >> " + commands,
+			},
+		}, template.EngineText)
+
+		require.NoError(t, WriteAll(outputs, model{Names: []string{"cas"}}, funcs))
+		require.NoError(t, os.Remove(commands))
+
+		require.NoError(t, WriteAll(outputs, model{Names: []string{"nginx"}}, funcs))
+		assert.Equal(t, "post inventory\n", readFile(t, commands))
+	})
+
+	t.Run("should write remaining outputs if an output fails", func(t *testing.T) {
+		dir := t.TempDir()
+		outputs := WithDefaultEngine([]Output{
+			{
+				Template: writeFile(t, filepath.Join(dir, "broken.tpl"), "{{.Missing}}"),
+				Target:   filepath.Join(dir, "broken.conf"),
+			},
+			{
+				Template:   writeFile(t, filepath.Join(dir, "checked.tpl"), "checked"),
+				Target:     filepath.Join(dir, "checked.conf"),
+				PreCommand: "false",
+			},
+			{
+				Template: writeFile(t, filepath.Join(dir, "app.conf.tpl"), "{{range .Names}}{{.}};{{end}}"),
+				Target:   filepath.Join(dir, "app.conf"),
+			},
+		}, template.EngineText)
+
+		err := WriteAll(outputs, model{Names: []string{"cas"}}, funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to write 2 of 3 outputs")
+		assert.Contains(t, err.Error(), "broken.conf: failed to render template")
+		assert.Contains(t, err.Error(), "checked.conf: pre check failed")
+
+		assert.Equal(t, "cas;", readFile(t, filepath.Join(dir, "app.conf")))
+		assert.NoFileExists(t, filepath.Join(dir, "broken.conf"))
+		assert.NoFileExists(t, filepath.Join(dir, "checked.conf"))
+	})
+}
+
+func TestValidate(t *testing.T) {
+	outputs := []Output{
+		{Target: "app.conf", Reload: reload.Reload{PidFile: "/run/nginx.pid", Signal: "USR1"}},
+		{Target: "upstreams.conf"},
+	}
+	require.NoError(t, Validate(outputs))
+
+	outputs = append(outputs, Output{Target: "maintenance.html", Reload: reload.Reload{PidFile: "/run/nginx.pid", Signal: "QUIT"}})
+	err := Validate(outputs)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid reload of maintenance.html")
+}
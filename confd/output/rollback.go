@@ -0,0 +1,115 @@
+package output
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudogu/ces-confd/confd/file"
+	"github.com/pkg/errors"
+)
+
+// lastKnownGoodPath returns the path of the copy of the last deployed target. The copy is hidden and has no known
+// extension, so that it is not picked up by includes of the target directory.
+func lastKnownGoodPath(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".last-known-good")
+}
+
+// keepLastKnownGood copies the current target to the last-known-good, if there is no last-known-good yet. The target
+// was deployed before ces-confd was started or before the first successful post-command.
+func keepLastKnownGood(target string) error {
+	lastKnownGood := lastKnownGoodPath(target)
+	_, err := os.Stat(lastKnownGood)
+	if err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to stat last-known-good %s", lastKnownGood)
+	}
+
+	content, err := os.ReadFile(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to read target %s", target)
+	}
+
+	return saveLastKnownGood(target, content)
+}
+
+// saveLastKnownGood stores the content of a deployed target as last-known-good
+func saveLastKnownGood(target string, content []byte) error {
+	err := file.WriteBytes(lastKnownGoodPath(target), file.DefaultMode, content)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save last-known-good of %s", target)
+	}
+	return nil
+}
+
+// restoreLastKnownGood replaces the target with the last-known-good and returns false, if there is no last-known-good
+func restoreLastKnownGood(target string) (bool, error) {
+	lastKnownGood := lastKnownGoodPath(target)
+	content, err := os.ReadFile(lastKnownGood)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "failed to read last-known-good %s", lastKnownGood)
+	}
+
+	log.Printf("restore last-known-good %s to %s", lastKnownGood, target)
+	err = file.WriteBytes(target, file.DefaultMode, content)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to restore last-known-good of %s", target)
+	}
+	return true, nil
+}
+
+// failedPath returns the path of the marker of a target, whose post-command or reload failed
+func failedPath(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".failed")
+}
+
+// markFailed remembers that the post-command or reload of the target failed, so that the next render executes them
+// again, even if the target is unchanged
+func markFailed(target string) {
+	err := file.WriteBytes(failedPath(target), file.DefaultMode, nil)
+	if err != nil {
+		log.Printf("failed to mark %s as failed: %v", target, err)
+	}
+}
+
+// clearFailed removes the marker of a failed post-command or reload
+func clearFailed(target string) {
+	err := os.Remove(failedPath(target))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove failed marker of %s: %v", target, err)
+	}
+}
+
+// hasFailed returns true, if the last post-command or reload of the target failed
+func hasFailed(target string) bool {
+	_, err := os.Stat(failedPath(target))
+	return err == nil
+}
+
+// quarantine records a failed candidate of the target in the quarantine directory for later inspection. A failure is
+// only logged, because the candidate is already rejected.
+func quarantine(directory string, target string, content []byte) {
+	if directory == "" {
+		return
+	}
+
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		log.Printf("failed to create quarantine directory %s: %v", directory, err)
+		return
+	}
+
+	name := filepath.Join(directory, filepath.Base(target)+"."+time.Now().UTC().Format("20060102T150405.000000000Z"))
+	err = file.WriteBytes(name, file.DefaultMode, content)
+	if err != nil {
+		log.Printf("failed to quarantine candidate of %s: %v", target, err)
+		return
+	}
+	log.Printf("quarantined failed candidate of %s as %s", target, name)
+}
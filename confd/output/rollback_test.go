@@ -0,0 +1,183 @@
+package output
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudogu/ces-confd/confd/reload"
+	"github.com/cloudogu/ces-confd/confd/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reloadOutput returns an output, whose post-command fails if the target contains "broken"
+func reloadOutput(t *testing.T, dir string) Output {
+	target := filepath.Join(dir, "app.conf")
+	commands := filepath.Join(dir, "commands")
+	return Output{
+		Template:       writeFile(t, filepath.Join(dir, "app.conf.tpl"), "{{.}}"),
+		Target:         target,
+		TemplateEngine: template.EngineText,
+		PostCommand:    "echo reload >> " + commands + " && ! grep -q broken " + target,
+		QuarantineDir:  filepath.Join(dir, "quarantine"),
+	}
+}
+
+func quarantined(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+
+	var contents []string
+	for _, entry := range entries {
+		contents = append(contents, readFile(t, filepath.Join(dir, entry.Name())))
+	}
+	return contents
+}
+
+func TestOutput_Write_rollback(t *testing.T) {
+	funcs := template.Funcs(context.Background(), nil)
+
+	t.Run("should keep deployed target as last-known-good", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+
+		require.NoError(t, output.Write("good", funcs))
+		assert.Equal(t, "good", readFile(t, lastKnownGoodPath(output.Target)))
+
+		require.NoError(t, output.Write("better", funcs))
+		assert.Equal(t, "better", readFile(t, lastKnownGoodPath(output.Target)))
+		assert.Empty(t, quarantined(t, output.QuarantineDir))
+	})
+
+	t.Run("should restore last-known-good and reload again if the post-command fails", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+		require.NoError(t, output.Write("good", funcs))
+		require.NoError(t, os.Remove(filepath.Join(dir, "commands")))
+
+		err := output.Write("broken", funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "restored last-known-good")
+
+		assert.Equal(t, "good", readFile(t, output.Target))
+		assert.Equal(t, "good", readFile(t, lastKnownGoodPath(output.Target)))
+		assert.Equal(t, "reload\nreload\n", readFile(t, filepath.Join(dir, "commands")))
+		assert.Equal(t, []string{"broken"}, quarantined(t, output.QuarantineDir))
+	})
+
+	t.Run("should use target of previous deployment as last-known-good", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+		writeFile(t, output.Target, "deployed")
+
+		err := output.Write("broken", funcs)
+		require.Error(t, err)
+
+		assert.Equal(t, "deployed", readFile(t, output.Target))
+	})
+
+	t.Run("should report failed rollback", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+		require.NoError(t, output.Write("good", funcs))
+		output.PostCommand = "false"
+
+		err := output.Write("broken", funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "post command failed again")
+		assert.Equal(t, "good", readFile(t, output.Target))
+	})
+
+	t.Run("should report missing last-known-good", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+
+		err := output.Write("broken", funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no last-known-good")
+		assert.Equal(t, []string{"broken"}, quarantined(t, output.QuarantineDir))
+	})
+
+	t.Run("should execute post-command of unchanged target again after it failed", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+		commands := filepath.Join(dir, "commands")
+		output.PostCommand = "echo reload >> " + commands + " && test -f " + filepath.Join(dir, "ready")
+
+		err := output.Write("good", funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no last-known-good")
+		assert.FileExists(t, failedPath(output.Target))
+
+		writeFile(t, filepath.Join(dir, "ready"), "")
+		require.NoError(t, output.Write("good", funcs))
+		assert.Equal(t, "reload\nreload\n", readFile(t, commands))
+		assert.NoFileExists(t, failedPath(output.Target))
+
+		require.NoError(t, output.Write("good", funcs))
+		assert.Equal(t, "reload\nreload\n", readFile(t, commands))
+	})
+
+	t.Run("should quarantine candidate which failed the pre-check", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+		output.PreCommand = `! grep -q broken "$CES_CONFD_CANDIDATE"`
+
+		err := output.Write("broken", funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "pre check failed")
+
+		assert.NoFileExists(t, output.Target)
+		assert.Equal(t, []string{"broken"}, quarantined(t, output.QuarantineDir))
+	})
+
+	t.Run("should not keep last-known-good without post-command", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOutput(t, dir)
+		output.PostCommand = ""
+
+		require.NoError(t, output.Write("good", funcs))
+		assert.NoFileExists(t, lastKnownGoodPath(output.Target))
+	})
+}
+
+func TestOutput_Write_reload(t *testing.T) {
+	funcs := template.Funcs(context.Background(), nil)
+
+	reloadOnly := func(t *testing.T, dir string, pid string) Output {
+		output := reloadOutput(t, dir)
+		output.PostCommand = ""
+		output.Reload = reload.Reload{PidFile: writeFile(t, filepath.Join(dir, "nginx.pid"), pid)}
+		return output
+	}
+
+	t.Run("should keep last-known-good with reload", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOnly(t, dir, "")
+		require.NoError(t, os.Remove(output.Reload.PidFile))
+
+		require.NoError(t, output.Write("good", funcs))
+		assert.Equal(t, "good", readFile(t, lastKnownGoodPath(output.Target)))
+	})
+
+	t.Run("should restore last-known-good if the reload fails", func(t *testing.T) {
+		dir := t.TempDir()
+		output := reloadOnly(t, dir, "")
+		require.NoError(t, os.Remove(output.Reload.PidFile))
+		require.NoError(t, output.Write("good", funcs))
+
+		writeFile(t, output.Reload.PidFile, "nginx")
+		err := output.Write("broken", funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to reload")
+		assert.Contains(t, err.Error(), "post command failed again")
+
+		assert.Equal(t, "good", readFile(t, output.Target))
+		assert.Equal(t, []string{"broken"}, quarantined(t, output.QuarantineDir))
+	})
+}
@@ -0,0 +1,371 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// treeReader is implemented by registries which can read a whole tree with a single request
+type treeReader interface {
+	GetTree(ctx context.Context, key string) (*Node, error)
+}
+
+// Snapshotter is implemented by registries which can provide a consistent view of their keys
+type Snapshotter interface {
+	// Snapshot returns a registry whose reads are not affected by later changes, watches are passed to the registry
+	Snapshot() Registry
+}
+
+// Snapshot returns a consistent view of the registry, if the registry supports snapshots, otherwise the registry
+// itself is returned. Generators should read all keys of a rebuild from a single snapshot.
+func Snapshot(registry Registry) Registry {
+	if snapshotter, ok := registry.(Snapshotter); ok {
+		return snapshotter.Snapshot()
+	}
+	return registry
+}
+
+// CachingRegistry mirrors the trees of a set of prefixes in memory and serves the reads of these keys from the
+// mirror. The mirror is loaded once and updated from the watches of the prefixes. Events are passed on to the
+// watches of the CachingRegistry after they were applied to the mirror, so that a read which is triggered by an
+// event always returns the changed value. Reads of keys outside of the prefixes or of prefixes which are not loaded
+// yet are passed to the registry.
+type CachingRegistry struct {
+	registry    Registry
+	prefixes    []string
+	retryPolicy RetryPolicy
+
+	mutex   sync.Mutex
+	roots   map[string]*cacheNode
+	history *eventLog
+}
+
+// cacheNode is an immutable node of the mirror, changes copy the path from the root to the changed node, so that a
+// snapshot is just a copy of the roots
+type cacheNode struct {
+	node     Node
+	children map[string]*cacheNode
+}
+
+// NewCachingRegistry creates a CachingRegistry which mirrors the minimal set of prefixes which covers all the given
+// keys
+func NewCachingRegistry(registry Registry, retryPolicy RetryPolicy, keys ...string) *CachingRegistry {
+	return &CachingRegistry{
+		registry:    registry,
+		prefixes:    minimalPrefixes(keys),
+		retryPolicy: retryPolicy,
+		roots:       map[string]*cacheNode{},
+		history:     newEventLog(DefaultHubHistory),
+	}
+}
+
+// Run loads the prefixes and keeps the mirror up to date. Run returns after the context is cancelled and all
+// watches of the registry have stopped.
+func (r *CachingRegistry) Run(ctx context.Context) {
+	var mirrors sync.WaitGroup
+	for _, prefix := range r.prefixes {
+		mirrors.Add(1)
+		go func(prefix string) {
+			defer mirrors.Done()
+			r.mirror(ctx, prefix)
+		}(prefix)
+	}
+	mirrors.Wait()
+	log.Println("stopped registry cache")
+}
+
+func (r *CachingRegistry) mirror(ctx context.Context, prefix string) {
+	if !r.load(ctx, prefix) {
+		return
+	}
+
+	events := make(chan *Event)
+	var watcher sync.WaitGroup
+	watcher.Add(1)
+	go func() {
+		defer watcher.Done()
+		r.registry.Watch(ctx, prefix, true, events)
+	}()
+	defer watcher.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if event.IsResync() {
+				log.Printf("reload cached tree %s, changes may have been lost", prefix)
+				if !r.load(ctx, prefix) {
+					return
+				}
+			}
+
+			r.mutex.Lock()
+			if !event.IsResync() {
+				r.roots[prefix] = r.roots[prefix].apply(prefix, event)
+			}
+			r.history.append(event)
+			r.mutex.Unlock()
+		}
+	}
+}
+
+// load reads the tree of the prefix into the mirror and retries failed reads with the delay of the retry policy. It
+// returns false, if the context was cancelled before the tree could be loaded.
+func (r *CachingRegistry) load(ctx context.Context, prefix string) bool {
+	retry := newBackoff(r.retryPolicy)
+	for {
+		tree, err := r.readTree(ctx, prefix)
+		if err == nil || IsKeyNotFound(err) {
+			r.mutex.Lock()
+			r.roots[prefix] = newCacheNode(tree)
+			r.mutex.Unlock()
+			return true
+		}
+
+		if ctx.Err() != nil {
+			return false
+		}
+
+		// reads are served by the registry until the tree could be loaded
+		r.mutex.Lock()
+		delete(r.roots, prefix)
+		r.mutex.Unlock()
+
+		delay := retry.next()
+		log.Printf("failed to load cached tree %s, retry in %s: %v", prefix, delay, err)
+		sleep(ctx, delay)
+	}
+}
+
+// readTree reads the whole tree of the key with a single request, if the registry supports it
+func (r *CachingRegistry) readTree(ctx context.Context, key string) (*Node, error) {
+	if reader, ok := r.registry.(treeReader); ok {
+		return reader.GetTree(ctx, key)
+	}
+
+	node, err := r.registry.Get(ctx, key)
+	if err != nil || !node.Dir {
+		return node, err
+	}
+
+	for i, child := range node.Nodes {
+		if child.Dir {
+			tree, err := r.readTree(ctx, child.Key)
+			if err != nil && !IsKeyNotFound(err) {
+				return nil, err
+			}
+			if tree != nil {
+				node.Nodes[i] = tree
+			}
+		}
+	}
+	return node, nil
+}
+
+// Get returns the node of the key from the mirror
+func (r *CachingRegistry) Get(ctx context.Context, key string) (*Node, error) {
+	return r.Snapshot().Get(ctx, key)
+}
+
+// Snapshot returns a view of the current state of the mirror. Keys which are not mirrored are read from the registry.
+func (r *CachingRegistry) Snapshot() Registry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	roots := make(map[string]*cacheNode, len(r.roots))
+	for prefix, root := range r.roots {
+		roots[prefix] = root
+	}
+	return &cacheSnapshot{cache: r, roots: roots, seq: r.history.seq}
+}
+
+// Watch sends the changes of the key to the channel after they were applied to the mirror. The watch starts after
+// the first read of the key or at the current position, if the key was not read before. Keys which are not mirrored
+// are watched directly. Watch returns only if the context is cancelled.
+func (r *CachingRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+	if r.prefixOf(key) == "" {
+		r.registry.Watch(ctx, key, recursive, eventChannel)
+		return
+	}
+
+	r.mutex.Lock()
+	seq := r.history.start(key)
+	r.mutex.Unlock()
+
+	r.history.follow(ctx, &r.mutex, seq, key, recursive, eventChannel)
+}
+
+// prefixOf returns the mirrored prefix which contains the key or an empty string
+func (r *CachingRegistry) prefixOf(key string) string {
+	for _, prefix := range r.prefixes {
+		if key == prefix || strings.HasPrefix(key, directoryPrefix(prefix)) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// cacheSnapshot serves reads from the roots of the mirror at the time the snapshot was created
+type cacheSnapshot struct {
+	cache *CachingRegistry
+	roots map[string]*cacheNode
+	// seq is the position of the snapshot in the history, watches of keys which were read from the snapshot start
+	// after this position
+	seq uint64
+}
+
+func (s *cacheSnapshot) Get(ctx context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+	prefix := s.cache.prefixOf(key)
+	if prefix == "" {
+		return s.cache.registry.Get(ctx, key)
+	}
+
+	s.cache.mutex.Lock()
+	s.cache.history.markRead(key, s.seq)
+	s.cache.mutex.Unlock()
+
+	root, loaded := s.roots[prefix]
+	if !loaded {
+		return s.cache.registry.Get(ctx, key)
+	}
+
+	found := root.find(prefix, key)
+	if found == nil {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+	return found.toNode(), nil
+}
+
+func (s *cacheSnapshot) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	s.cache.Watch(ctx, key, recursive, eventChannel)
+}
+
+func newCacheNode(node *Node) *cacheNode {
+	if node == nil {
+		return nil
+	}
+
+	converted := &cacheNode{node: Node{Key: normalizeKey(node.Key), Value: node.Value, Dir: node.Dir, ModifiedIndex: node.ModifiedIndex}}
+	if node.Dir {
+		converted.children = map[string]*cacheNode{}
+		for _, child := range node.Nodes {
+			convertedChild := newCacheNode(child)
+			converted.children[convertedChild.node.Name()] = convertedChild
+		}
+	}
+	return converted
+}
+
+// toNode returns the node with its direct children
+func (n *cacheNode) toNode() *Node {
+	node := n.node
+	if !node.Dir {
+		return &node
+	}
+
+	node.Nodes = Nodes{}
+	for _, child := range n.children {
+		copied := child.node
+		node.Nodes = append(node.Nodes, &copied)
+	}
+	sort.Slice(node.Nodes, func(i, j int) bool {
+		return node.Nodes[i].Key < node.Nodes[j].Key
+	})
+	return &node
+}
+
+// find returns the node of the key below the root of the prefix or nil
+func (n *cacheNode) find(prefix string, key string) *cacheNode {
+	current := n
+	for _, segment := range segments(prefix, key) {
+		if current == nil {
+			return nil
+		}
+		current = current.children[segment]
+	}
+	return current
+}
+
+// apply returns the root of the prefix after the event
+func (n *cacheNode) apply(prefix string, event *Event) *cacheNode {
+	if event.Node == nil {
+		return n
+	}
+
+	key := normalizeKey(event.Node.Key)
+	switch event.Action {
+	case ActionDelete, ActionExpire, ActionCompareAndDelete:
+		return n.without(prefix, segments(prefix, key))
+	default:
+		return n.with(prefix, segments(prefix, key), event.Node)
+	}
+}
+
+// with returns a copy of the node which contains the changed node at the path of the segments, missing directories
+// are created
+func (n *cacheNode) with(key string, segments []string, changed *Node) *cacheNode {
+	if len(segments) == 0 {
+		replacement := &cacheNode{node: Node{Key: key, Value: changed.Value, Dir: changed.Dir, ModifiedIndex: changed.ModifiedIndex}}
+		if changed.Dir {
+			replacement.children = map[string]*cacheNode{}
+			// an existing directory keeps its children
+			if n != nil && n.node.Dir {
+				replacement.children = n.children
+			}
+		}
+		return replacement
+	}
+
+	copied := &cacheNode{node: Node{Key: key, Dir: true}, children: map[string]*cacheNode{}}
+	if n != nil && n.node.Dir {
+		copied.node = n.node
+		for name, child := range n.children {
+			copied.children[name] = child
+		}
+	}
+
+	name := segments[0]
+	copied.children[name] = copied.children[name].with(path.Join(key, name), segments[1:], changed)
+	return copied
+}
+
+// without returns a copy of the node without the node at the path of the segments
+func (n *cacheNode) without(key string, segments []string) *cacheNode {
+	if len(segments) == 0 || n == nil {
+		return nil
+	}
+
+	child, exists := n.children[segments[0]]
+	if !exists {
+		return n
+	}
+
+	copied := &cacheNode{node: n.node, children: make(map[string]*cacheNode, len(n.children))}
+	for name, other := range n.children {
+		copied.children[name] = other
+	}
+
+	remaining := child.without(path.Join(key, segments[0]), segments[1:])
+	if remaining == nil {
+		delete(copied.children, segments[0])
+	} else {
+		copied.children[segments[0]] = remaining
+	}
+	return copied
+}
+
+// segments returns the path segments of the key below the prefix
+func segments(prefix string, key string) []string {
+	rest := strings.Trim(strings.TrimPrefix(key, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
@@ -0,0 +1,308 @@
+package registry
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRegistry counts the reads of the memory registry
+type countingRegistry struct {
+	*MemoryRegistry
+	gets int32
+}
+
+func (r *countingRegistry) Get(ctx context.Context, key string) (*Node, error) {
+	atomic.AddInt32(&r.gets, 1)
+	return r.MemoryRegistry.Get(ctx, key)
+}
+
+// plainRegistry hides the GetTree method of the memory registry
+type plainRegistry struct {
+	Registry
+}
+
+// resyncRegistry sends the events of its channel instead of watching the memory registry
+type resyncRegistry struct {
+	*MemoryRegistry
+	events chan *Event
+}
+
+func (r *resyncRegistry) Watch(ctx context.Context, _ string, _ bool, eventChannel chan *Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-r.events:
+			if !send(ctx, eventChannel, event) {
+				return
+			}
+		}
+	}
+}
+
+func startCache(t *testing.T, cache *CachingRegistry) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ctx
+}
+
+// awaitLoaded waits until the prefix was loaded into the mirror
+func awaitLoaded(t *testing.T, cache *CachingRegistry, prefix string) {
+	require.Eventually(t, func() bool {
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+		_, loaded := cache.roots[prefix]
+		return loaded
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCachingRegistry_Get(t *testing.T) {
+	registry := &countingRegistry{MemoryRegistry: NewMemoryRegistry()}
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+	require.NoError(t, registry.Set("/services/nginx/one", "nginx"))
+	require.NoError(t, registry.Set("/config/_global/fqdn", "ces.local"))
+
+	cache := NewCachingRegistry(registry, RetryPolicy{}, "/services")
+	ctx := startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	t.Run("should read mirrored keys without request", func(t *testing.T) {
+		before := atomic.LoadInt32(&registry.gets)
+
+		node, err := cache.Get(ctx, "/services")
+		require.NoError(t, err)
+		require.Len(t, node.Nodes, 2)
+		assert.Equal(t, "/services/cas", node.Nodes[0].Key)
+		assert.True(t, node.Nodes[0].Dir)
+		assert.Empty(t, node.Nodes[0].Nodes)
+
+		node, err = cache.Get(ctx, "services/nginx/one")
+		require.NoError(t, err)
+		assert.Equal(t, "nginx", node.Value)
+
+		assert.Equal(t, before, atomic.LoadInt32(&registry.gets))
+	})
+
+	t.Run("should return key not found error for missing mirrored key", func(t *testing.T) {
+		_, err := cache.Get(ctx, "/services/ldap")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFound(err))
+	})
+
+	t.Run("should read other keys from registry", func(t *testing.T) {
+		before := atomic.LoadInt32(&registry.gets)
+
+		node, err := cache.Get(ctx, "/config/_global/fqdn")
+		require.NoError(t, err)
+		assert.Equal(t, "ces.local", node.Value)
+
+		assert.Equal(t, before+1, atomic.LoadInt32(&registry.gets))
+	})
+}
+
+func TestCachingRegistry_LoadWithoutGetTree(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+	require.NoError(t, registry.Set("/services/cas/nested/two", "two"))
+
+	cache := NewCachingRegistry(plainRegistry{registry}, RetryPolicy{}, "/services")
+	ctx := startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	node, err := cache.Get(ctx, "/services/cas/nested/two")
+	require.NoError(t, err)
+	assert.Equal(t, "two", node.Value)
+}
+
+func TestCachingRegistry_LoadMissingPrefix(t *testing.T) {
+	registry := NewMemoryRegistry()
+	// the read of the root pins the start of the watches, because the missing prefix cannot be read
+	_, err := registry.Get(context.Background(), "/")
+	require.NoError(t, err)
+
+	cache := NewCachingRegistry(registry, RetryPolicy{}, "/services")
+	ctx := startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	_, err = cache.Get(ctx, "/services")
+	require.Error(t, err)
+	assert.True(t, IsKeyNotFound(err))
+
+	eventChannel := watch(t, cache, "/services", true)
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+	receive(t, eventChannel)
+
+	node, err := cache.Get(ctx, "/services/cas/one")
+	require.NoError(t, err)
+	assert.Equal(t, "cas", node.Value)
+}
+
+func TestCachingRegistry_Watch(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+	require.NoError(t, registry.Set("/services/nginx/one", "nginx"))
+
+	cache := NewCachingRegistry(registry, RetryPolicy{}, "/services")
+	ctx := startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	_, err := cache.Get(ctx, "/services")
+	require.NoError(t, err)
+	eventChannel := watch(t, cache, "/services", true)
+
+	t.Run("should apply change before the event is sent", func(t *testing.T) {
+		require.NoError(t, registry.Set("/services/cas/one", "changed"))
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, "/services/cas/one", event.Key())
+
+		node, err := cache.Get(ctx, "/services/cas/one")
+		require.NoError(t, err)
+		assert.Equal(t, "changed", node.Value)
+	})
+
+	t.Run("should create missing directories", func(t *testing.T) {
+		require.NoError(t, registry.Set("/services/ldap/one", "ldap"))
+		receive(t, eventChannel)
+
+		node, err := cache.Get(ctx, "/services")
+		require.NoError(t, err)
+		require.Len(t, node.Nodes, 3)
+		assert.Equal(t, "/services/ldap", node.Nodes[1].Key)
+		assert.True(t, node.Nodes[1].Dir)
+	})
+
+	t.Run("should remove deleted directory with its children", func(t *testing.T) {
+		require.NoError(t, registry.Delete("/services/nginx", true))
+		receive(t, eventChannel)
+
+		_, err := cache.Get(ctx, "/services/nginx/one")
+		assert.True(t, IsKeyNotFound(err))
+
+		node, err := cache.Get(ctx, "/services")
+		require.NoError(t, err)
+		assert.Len(t, node.Nodes, 2)
+	})
+
+	t.Run("should not send events of other keys", func(t *testing.T) {
+		require.NoError(t, registry.Set("/config/_global/fqdn", "ces.local"))
+		assertNoEvent(t, eventChannel)
+	})
+}
+
+func TestCachingRegistry_Snapshot(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+
+	cache := NewCachingRegistry(registry, RetryPolicy{}, "/services")
+	ctx := startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	snapshot := Snapshot(cache)
+	_, err := snapshot.Get(ctx, "/services")
+	require.NoError(t, err)
+
+	eventChannel := make(chan *Event, 16)
+	go snapshot.Watch(ctx, "/services", true, eventChannel)
+
+	require.NoError(t, registry.Set("/services/cas/one", "changed"))
+	require.NoError(t, registry.Set("/services/nginx/one", "nginx"))
+	receive(t, eventChannel)
+	receive(t, eventChannel)
+
+	t.Run("should not see changes after the snapshot", func(t *testing.T) {
+		node, err := snapshot.Get(ctx, "/services/cas/one")
+		require.NoError(t, err)
+		assert.Equal(t, "cas", node.Value)
+
+		_, err = snapshot.Get(ctx, "/services/nginx/one")
+		assert.True(t, IsKeyNotFound(err))
+	})
+
+	t.Run("should see changes in a new snapshot", func(t *testing.T) {
+		node, err := Snapshot(cache).Get(ctx, "/services/cas/one")
+		require.NoError(t, err)
+		assert.Equal(t, "changed", node.Value)
+	})
+
+	t.Run("should return registry without snapshot support", func(t *testing.T) {
+		assert.Same(t, registry, Snapshot(registry))
+	})
+}
+
+func TestCachingRegistry_Resync(t *testing.T) {
+	registry := &resyncRegistry{MemoryRegistry: NewMemoryRegistry(), events: make(chan *Event)}
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+
+	cache := NewCachingRegistry(registry, RetryPolicy{}, "/services")
+	ctx := startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	_, err := cache.Get(ctx, "/services")
+	require.NoError(t, err)
+	eventChannel := watch(t, cache, "/services", true)
+
+	// the change is lost, only the resync reaches the cache
+	require.NoError(t, registry.Set("/services/cas/one", "changed"))
+	registry.events <- &Event{Action: ActionResync, Node: &Node{Key: "/services", Dir: true}}
+
+	event := receive(t, eventChannel)
+	assert.True(t, event.IsResync())
+
+	node, err := cache.Get(ctx, "/services/cas/one")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", node.Value)
+}
+
+func TestCachingRegistry_WithWatchHub(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+
+	cache := NewCachingRegistry(registry, RetryPolicy{}, "/services")
+	startCache(t, cache)
+	awaitLoaded(t, cache, "/services")
+
+	hub := NewWatchHub(cache, "/services")
+	ctx := startHub(t, hub)
+
+	_, err := Snapshot(hub).Get(ctx, "/services")
+	require.NoError(t, err)
+	eventChannel := watch(t, hub, "/services", true)
+
+	require.NoError(t, registry.Set("/services/cas/one", "changed"))
+	receive(t, eventChannel)
+
+	node, err := Snapshot(hub).Get(ctx, "/services/cas/one")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", node.Value)
+}
+
+func TestCacheNode_Apply(t *testing.T) {
+	root := newCacheNode(&Node{Key: "/services", Dir: true, Nodes: Nodes{
+		{Key: "/services/cas", Dir: true, Nodes: Nodes{{Key: "/services/cas/one", Value: "cas"}}},
+	}})
+
+	changed := root.apply("/services", &Event{Action: ActionSet, Node: &Node{Key: "/services/cas/two", Value: "two"}})
+	assert.Equal(t, "two", changed.find("/services", "/services/cas/two").node.Value)
+	assert.Equal(t, "cas", changed.find("/services", "/services/cas/one").node.Value)
+	assert.Nil(t, root.find("/services", "/services/cas/two"), "the original tree must not be modified")
+
+	removed := changed.apply("/services", &Event{Action: ActionCompareAndDelete, Node: &Node{Key: "/services/cas/one"}})
+	assert.Nil(t, removed.find("/services", "/services/cas/one"))
+	assert.NotNil(t, changed.find("/services", "/services/cas/one"))
+
+	assert.Nil(t, removed.apply("/services", &Event{Action: ActionDelete, Node: &Node{Key: "/services", Dir: true}}))
+}
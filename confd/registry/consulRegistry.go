@@ -0,0 +1,462 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultConsulWaitTime is the maximum duration of a blocking query of a watch, consul answers earlier if a watched key
+// changes
+const DefaultConsulWaitTime = 5 * time.Minute
+
+// ConsulRegistry implements the Registry interface on top of the consul kv http api. Like the etcd v3 api consul has a
+// flat keyspace without directories, every key which is a prefix of other keys (separated by a slash) is treated as a
+// directory. Keys with a trailing slash, which are created as folders by the consul ui, are empty directories. The
+// keys are stored without the leading slash of the registry keys, e.g. the key /services/cas is services/cas in
+// consul.
+//
+// Watches are implemented with blocking queries on the X-Consul-Index. Consul returns all watched keys on every change,
+// the events are created by comparing the keys with the result of the previous query.
+type ConsulRegistry struct {
+	httpClient    *http.Client
+	endpoints     []string
+	token         string
+	username      string
+	password      string
+	retryPolicy   RetryPolicy
+	headerTimeout time.Duration
+	waitTime      time.Duration
+
+	endpointMutex sync.Mutex
+	endpoint      int
+
+	indexMutex  sync.Mutex
+	recentIndex uint64
+}
+
+// consulEntry is a key of the response of the consul kv api, the value is base64 encoded in the json
+type consulEntry struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+// consulStatusError is returned if consul answers with an unexpected status code
+type consulStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *consulStatusError) Error() string {
+	return fmt.Sprintf("consul responded with status %d: %s", err.StatusCode, err.Message)
+}
+
+// consulWatch is the state of a watch, which is kept across failed queries
+type consulWatch struct {
+	key       string
+	recursive bool
+	// start is the index of the first read of the registry, changes after the index are sent by the first query
+	start uint64
+	index uint64
+	// nodes contains the watched keys of the last query, it is nil until the first query succeeded
+	nodes map[string]*Node
+}
+
+// NewConsulRegistry creates and configures a new ConsulRegistry. The endpoints are the http urls of consul agents,
+// e.g. http://localhost:8500, the next endpoint is used if an agent cannot be reached.
+func NewConsulRegistry(config Config) (*ConsulRegistry, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("consul registry requires at least one endpoint")
+	}
+
+	tlsConfig, err := config.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   config.dialTimeout(),
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		},
+	}
+
+	return &ConsulRegistry{
+		httpClient:    httpClient,
+		endpoints:     config.Endpoints,
+		token:         config.Token,
+		username:      config.Username,
+		password:      config.Password,
+		retryPolicy:   config.Retry,
+		headerTimeout: config.HeaderTimeout,
+		waitTime:      DefaultConsulWaitTime,
+	}, nil
+}
+
+// Get returns the value associated with the provided key. If the key is a directory, the response contains the
+// direct children of the directory, like a non recursive get of the etcd v2 api.
+func (r *ConsulRegistry) Get(ctx context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	nodes, index, err := r.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+
+	r.updateIndexIfNecessary(index)
+	if nodes[0].Key == key {
+		if !nodes[0].Dir {
+			return nodes[0], nil
+		}
+		// the folder of the directory itself is not a child
+		nodes = nodes[1:]
+	}
+	return &Node{Key: key, Dir: true, Nodes: directChildren(directoryPrefix(key), nodes)}, nil
+}
+
+// GetTree returns the node of the key with all of its descendants, which are read with a single request. Like Get a
+// key with a value is returned as value, even if there are keys below it.
+func (r *ConsulRegistry) GetTree(ctx context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	nodes, index, err := r.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+
+	r.updateIndexIfNecessary(index)
+	if nodes[0].Key == key && !nodes[0].Dir {
+		return nodes[0], nil
+	}
+
+	prefix := directoryPrefix(key)
+	root := &Node{Key: key, Dir: true}
+	for _, node := range nodes {
+		if node.Key != key {
+			insertNode(root, prefix, node)
+		}
+	}
+	return root, nil
+}
+
+// read returns the key and all keys below it, limited by the header timeout
+func (r *ConsulRegistry) read(ctx context.Context, key string) (Nodes, uint64, error) {
+	if r.headerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.headerTimeout)
+		defer cancel()
+	}
+
+	nodes, index, err := r.query(ctx, key, true, 0)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read key %s", key)
+	}
+	return nodes, index, nil
+}
+
+// updateIndexIfNecessary remembers the index of the first read as the start of all watches
+func (r *ConsulRegistry) updateIndexIfNecessary(index uint64) {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	if r.recentIndex == 0 {
+		r.recentIndex = index
+	}
+}
+
+// startIndex returns the index after which new watches start
+func (r *ConsulRegistry) startIndex() uint64 {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	return r.recentIndex
+}
+
+// Watch watches for changes of the provided key and sends the event through the channel. The first query sends the
+// changes after the first read of the registry. Consul does not keep the history of a key, deleted keys can only be
+// detected by the index of the query. If keys were deleted between the read and the first query or if consul has
+// reset the index, a resync event is sent. A failed query is repeated after the delay of the retry policy. Watch
+// returns only if the context is cancelled.
+func (r *ConsulRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	watch := &consulWatch{key: normalizeKey(key), recursive: recursive, start: r.startIndex()}
+	retry := newBackoff(r.retryPolicy)
+
+	for {
+		progress, err := r.watch(ctx, watch, eventChannel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if progress {
+			retry.reset()
+		}
+
+		class := classifyWatchError(err)
+		watchRetries.Add(class.String(), 1)
+
+		delay := retry.next()
+		log.Printf("watch of %s failed (%s), retry attempt %d in %v: %v", watch.key, class, retry.attempt, delay, err)
+		sleep(ctx, delay)
+	}
+}
+
+// watch runs blocking queries and sends the changes until a query fails. It returns true if at least one query
+// succeeded.
+func (r *ConsulRegistry) watch(ctx context.Context, watch *consulWatch, eventChannel chan *Event) (bool, error) {
+	progress := false
+	for {
+		var index uint64
+		if watch.nodes != nil {
+			index = watch.index
+		}
+
+		nodes, current, err := r.query(ctx, watch.key, watch.recursive, index)
+		if err != nil {
+			return progress, err
+		}
+		progress = true
+
+		// consul requires an index greater than zero for blocking queries
+		if current == 0 {
+			current = 1
+		}
+
+		var events []*Event
+		switch {
+		case watch.nodes == nil:
+			events = watch.initialEvents(nodes, current)
+		case current < watch.index:
+			log.Printf("consul has reset the index of watch %s from %d to %d, resync", watch.key, watch.index, current)
+			events = []*Event{watch.resync(current)}
+		case current == watch.index:
+			// the wait time of the blocking query has expired without a change
+			continue
+		default:
+			events = watch.changes(nodes, current)
+		}
+
+		watch.nodes = nodeMap(nodes)
+		watch.index = current
+
+		for _, event := range events {
+			if !send(ctx, eventChannel, event) {
+				return progress, ctx.Err()
+			}
+		}
+	}
+}
+
+// initialEvents returns the changes after the start index. Deleted keys are not returned by consul, if the index of
+// the query is greater than the indices of all remaining keys, a key was deleted and a resync is required.
+func (watch *consulWatch) initialEvents(nodes Nodes, index uint64) []*Event {
+	if watch.start == 0 || index <= watch.start {
+		return nil
+	}
+
+	var events []*Event
+	var lastModified uint64
+	for _, node := range nodes {
+		if node.ModifiedIndex > lastModified {
+			lastModified = node.ModifiedIndex
+		}
+		if node.ModifiedIndex > watch.start {
+			events = append(events, &Event{Action: ActionSet, Node: node, Index: index})
+		}
+	}
+
+	if index > lastModified {
+		return []*Event{watch.resync(index)}
+	}
+
+	sortEvents(events)
+	return events
+}
+
+// changes compares the nodes of the query with the nodes of the previous query
+func (watch *consulWatch) changes(nodes Nodes, index uint64) []*Event {
+	var events []*Event
+	current := nodeMap(nodes)
+
+	for key, node := range current {
+		prev, exists := watch.nodes[key]
+		switch {
+		case !exists:
+			events = append(events, &Event{Action: ActionCreate, Node: node, Index: index})
+		case prev.ModifiedIndex != node.ModifiedIndex:
+			events = append(events, &Event{Action: ActionSet, Node: node, PrevNode: prev, Index: index})
+		}
+	}
+
+	for key, prev := range watch.nodes {
+		if _, exists := current[key]; !exists {
+			deleted := &Node{Key: key, Dir: prev.Dir, ModifiedIndex: index}
+			events = append(events, &Event{Action: ActionDelete, Node: deleted, PrevNode: prev, Index: index})
+		}
+	}
+
+	sortEvents(events)
+	return events
+}
+
+func (watch *consulWatch) resync(index uint64) *Event {
+	return &Event{Action: ActionResync, Node: &Node{Key: watch.key, Dir: watch.recursive}, Index: index}
+}
+
+// sortEvents orders the events by the index of their change and by their key
+func sortEvents(events []*Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Node.ModifiedIndex != events[j].Node.ModifiedIndex {
+			return events[i].Node.ModifiedIndex < events[j].Node.ModifiedIndex
+		}
+		return events[i].Node.Key < events[j].Node.Key
+	})
+}
+
+func nodeMap(nodes Nodes) map[string]*Node {
+	mapped := make(map[string]*Node, len(nodes))
+	for _, node := range nodes {
+		mapped[node.Key] = node
+	}
+	return mapped
+}
+
+// query reads the key, recursive queries return also all keys below the key. If the index is greater than zero, the
+// query blocks until the index of the keys has changed or the wait time has expired. The nodes are sorted by their
+// keys, a missing key returns no nodes. If an agent cannot be reached, the next query uses the next endpoint.
+func (r *ConsulRegistry) query(ctx context.Context, key string, recursive bool, index uint64) (Nodes, uint64, error) {
+	endpoint := r.currentEndpoint()
+
+	parameters := url.Values{}
+	if recursive {
+		parameters.Set("recurse", "true")
+	}
+	if index > 0 {
+		parameters.Set("index", strconv.FormatUint(index, 10))
+		parameters.Set("wait", r.waitTime.String())
+	}
+
+	requestURL := strings.TrimSuffix(endpoint, "/") + "/v1/kv/" + consulPath(key)
+	if len(parameters) > 0 {
+		requestURL += "?" + parameters.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to create request for %s", requestURL)
+	}
+	if r.token != "" {
+		request.Header.Set("X-Consul-Token", r.token)
+	}
+	if r.username != "" {
+		request.SetBasicAuth(r.username, r.password)
+	}
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.nextEndpoint(endpoint)
+		}
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	var consulIndex uint64
+	if header := response.Header.Get("X-Consul-Index"); header != "" {
+		consulIndex, err = strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "invalid consul index %s", header)
+		}
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Nodes{}, consulIndex, nil
+	default:
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, 0, &consulStatusError{StatusCode: response.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	var entries []consulEntry
+	err = json.NewDecoder(response.Body).Decode(&entries)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to decode consul response")
+	}
+
+	return consulNodes(key, recursive, entries), consulIndex, nil
+}
+
+func (r *ConsulRegistry) currentEndpoint() string {
+	r.endpointMutex.Lock()
+	defer r.endpointMutex.Unlock()
+	return r.endpoints[r.endpoint]
+}
+
+// nextEndpoint switches to the next endpoint, if the failed endpoint is still the current one
+func (r *ConsulRegistry) nextEndpoint(failed string) {
+	r.endpointMutex.Lock()
+	defer r.endpointMutex.Unlock()
+	if len(r.endpoints) > 1 && r.endpoints[r.endpoint] == failed {
+		r.endpoint = (r.endpoint + 1) % len(r.endpoints)
+		log.Printf("consul endpoint %s is not reachable, switch to %s", failed, r.endpoints[r.endpoint])
+	}
+}
+
+// consulNodes converts the entries of a response into sorted nodes. The recursive query of consul matches also
+// siblings with the same prefix e.g. services-x for services, which are removed.
+func consulNodes(key string, recursive bool, entries []consulEntry) Nodes {
+	nodes := Nodes{}
+	for _, entry := range entries {
+		node := &Node{
+			Key:           normalizeKey(entry.Key),
+			Value:         string(entry.Value),
+			Dir:           strings.HasSuffix(entry.Key, "/"),
+			ModifiedIndex: entry.ModifyIndex,
+		}
+		if node.Dir {
+			node.Value = ""
+		}
+
+		if node.Key == key || (recursive && strings.HasPrefix(node.Key, directoryPrefix(key))) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Key < nodes[j].Key
+	})
+	return nodes
+}
+
+// consulPath converts the registry key into the escaped path of the consul key
+func consulPath(key string) string {
+	segments := strings.Split(strings.Trim(key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
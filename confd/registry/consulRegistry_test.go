@@ -0,0 +1,338 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsul is a stand-in for the kv api of a consul agent. Like consul the index of a query is the highest modify
+// index of the matching keys and of their tombstones, or the index of the store if no key matches.
+type fakeConsul struct {
+	mutex      sync.Mutex
+	index      uint64
+	entries    map[string]consulEntry
+	tombstones map[string]uint64
+	changed    chan struct{}
+	token      string
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, *httptest.Server) {
+	consul := &fakeConsul{
+		index:      1,
+		entries:    map[string]consulEntry{},
+		tombstones: map[string]uint64{},
+		changed:    make(chan struct{}),
+	}
+	server := httptest.NewServer(consul)
+	t.Cleanup(server.Close)
+	return consul, server
+}
+
+func newTestConsulRegistry(t *testing.T, endpoints ...string) *ConsulRegistry {
+	registry, err := NewConsulRegistry(Config{
+		Endpoints: endpoints,
+		Token:     "secret",
+		Retry:     RetryPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	registry.waitTime = time.Second
+	return registry
+}
+
+func (c *fakeConsul) put(key string, value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.index++
+	c.entries[key] = consulEntry{Key: key, Value: []byte(value), ModifyIndex: c.index}
+	delete(c.tombstones, key)
+	c.notify()
+}
+
+func (c *fakeConsul) delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.index++
+	delete(c.entries, key)
+	c.tombstones[key] = c.index
+	c.notify()
+}
+
+// reap removes the tombstones, which resets the index of the keys like the garbage collection of consul
+func (c *fakeConsul) reap() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tombstones = map[string]uint64{}
+	c.notify()
+}
+
+func (c *fakeConsul) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != c.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	_, recurse := r.URL.Query()["recurse"]
+	minIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	timeout := time.After(wait)
+
+	for {
+		c.mutex.Lock()
+		entries, index := c.query(key, recurse)
+		changed := c.changed
+		c.mutex.Unlock()
+
+		if minIndex == 0 || index != minIndex {
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+			if len(entries) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(entries)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			minIndex = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (c *fakeConsul) query(key string, recurse bool) ([]consulEntry, uint64) {
+	matches := func(candidate string) bool {
+		return candidate == key || (recurse && strings.HasPrefix(candidate, key))
+	}
+
+	var entries []consulEntry
+	var index uint64
+	for candidate, entry := range c.entries {
+		if matches(candidate) {
+			entries = append(entries, entry)
+			if entry.ModifyIndex > index {
+				index = entry.ModifyIndex
+			}
+		}
+	}
+	for candidate, deleted := range c.tombstones {
+		if matches(candidate) && deleted > index {
+			index = deleted
+		}
+	}
+
+	if index == 0 {
+		index = c.index
+	}
+	return entries, index
+}
+
+func TestNewConsulRegistry(t *testing.T) {
+	_, err := NewConsulRegistry(Config{})
+	assert.Error(t, err)
+
+	registry, err := New(context.Background(), Config{Backend: BackendConsul, Endpoints: []string{"http://localhost:8500"}})
+	require.NoError(t, err)
+	assert.IsType(t, &ConsulRegistry{}, registry)
+}
+
+func TestConsulRegistry_Get(t *testing.T) {
+	consul, server := newFakeConsul(t)
+	consul.token = "secret"
+	consul.put("services/nginx/one", "{\"name\": \"nginx\"}")
+	consul.put("services/cas/one", "{\"name\": \"cas\"}")
+	consul.put("services/cas/two", "{\"name\": \"cas\"}")
+	consul.put("services/ldap/", "")
+	consul.put("servicesX/other", "other")
+	consul.put("config/_global/maintenance", "{\"title\": \"Maintenance\"}")
+
+	registry := newTestConsulRegistry(t, server.URL)
+
+	t.Run("should return value of key", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/config/_global/maintenance")
+		require.NoError(t, err)
+		assert.False(t, node.Dir)
+		assert.Equal(t, "/config/_global/maintenance", node.Key)
+		assert.Equal(t, "{\"title\": \"Maintenance\"}", node.Value)
+	})
+
+	t.Run("should return direct children of directory", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "services")
+		require.NoError(t, err)
+		assert.True(t, node.Dir)
+		require.Len(t, node.Nodes, 3)
+		assert.Equal(t, "/services/cas", node.Nodes[0].Key)
+		assert.True(t, node.Nodes[0].Dir)
+		assert.Equal(t, "/services/ldap", node.Nodes[1].Key)
+		assert.True(t, node.Nodes[1].Dir)
+		assert.Equal(t, "/services/nginx", node.Nodes[2].Key)
+	})
+
+	t.Run("should return empty folder as directory", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services/ldap")
+		require.NoError(t, err)
+		assert.True(t, node.Dir)
+		assert.Empty(t, node.Nodes)
+	})
+
+	t.Run("should return whole tree", func(t *testing.T) {
+		node, err := registry.GetTree(context.Background(), "/services")
+		require.NoError(t, err)
+		require.Len(t, node.Nodes, 3)
+		require.Len(t, node.Nodes[0].Nodes, 2)
+		assert.Equal(t, "/services/cas/two", node.Nodes[0].Nodes[1].Key)
+		assert.Equal(t, "{\"name\": \"cas\"}", node.Nodes[0].Nodes[1].Value)
+		assert.Empty(t, node.Nodes[1].Nodes)
+		assert.Equal(t, "/services/nginx/one", node.Nodes[2].Nodes[0].Key)
+	})
+
+	t.Run("should return key not found error", func(t *testing.T) {
+		_, err := registry.Get(context.Background(), "/dogu")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFound(err))
+	})
+
+	t.Run("should return error of rejected token", func(t *testing.T) {
+		registry, err := NewConsulRegistry(Config{Endpoints: []string{server.URL}, Token: "wrong"})
+		require.NoError(t, err)
+
+		_, err = registry.Get(context.Background(), "/services")
+		require.Error(t, err)
+		assert.Equal(t, watchErrorUnauthorized, classifyWatchError(err))
+	})
+}
+
+func TestConsulRegistry_GetWithFailover(t *testing.T) {
+	consul, server := newFakeConsul(t)
+	consul.put("config/_global/fqdn", "ces.local")
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	registry, err := NewConsulRegistry(Config{Endpoints: []string{unreachable.URL, server.URL}})
+	require.NoError(t, err)
+
+	_, err = registry.Get(context.Background(), "/config/_global/fqdn")
+	require.Error(t, err)
+	assert.Equal(t, watchErrorUnavailable, classifyWatchError(err))
+
+	node, err := registry.Get(context.Background(), "/config/_global/fqdn")
+	require.NoError(t, err)
+	assert.Equal(t, "ces.local", node.Value)
+}
+
+func TestConsulRegistry_Watch(t *testing.T) {
+	consul, server := newFakeConsul(t)
+	consul.token = "secret"
+	consul.put("services/cas/one", "cas")
+
+	registry := newTestConsulRegistry(t, server.URL)
+	_, err := registry.Get(context.Background(), "/services")
+	require.NoError(t, err)
+
+	// the change between the read and the start of the watch must not be lost
+	consul.put("services/cas/one", "changed")
+	eventChannel := watch(t, registry, "/services", true)
+
+	t.Run("should send changes after the first read", func(t *testing.T) {
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionSet, event.Action)
+		assert.Equal(t, "/services/cas/one", event.Key())
+		assert.Equal(t, "changed", event.Node.Value)
+	})
+
+	t.Run("should send created keys", func(t *testing.T) {
+		consul.put("services/nginx/one", "nginx")
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionCreate, event.Action)
+		assert.Equal(t, "/services/nginx/one", event.Key())
+		assert.Equal(t, "nginx", event.Node.Value)
+	})
+
+	t.Run("should send updated keys with previous value", func(t *testing.T) {
+		consul.put("services/nginx/one", "updated")
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionSet, event.Action)
+		assert.Equal(t, "updated", event.Node.Value)
+		assert.Equal(t, "nginx", event.PrevValue())
+	})
+
+	t.Run("should send deleted keys", func(t *testing.T) {
+		consul.delete("services/nginx/one")
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionDelete, event.Action)
+		assert.Equal(t, "/services/nginx/one", event.Key())
+		assert.Equal(t, "updated", event.PrevValue())
+	})
+
+	t.Run("should resync after index reset", func(t *testing.T) {
+		consul.reap()
+
+		event := receive(t, eventChannel)
+		assert.True(t, event.IsResync())
+		assert.Equal(t, "/services", event.Key())
+	})
+
+	t.Run("should ignore siblings with the same prefix", func(t *testing.T) {
+		consul.put("servicesX/other", "other")
+		assertNoEvent(t, eventChannel)
+	})
+}
+
+func TestConsulRegistry_WatchDeletedBeforeStart(t *testing.T) {
+	consul, server := newFakeConsul(t)
+	consul.put("services/cas/one", "cas")
+	consul.put("services/cas/two", "cas")
+
+	registry := newTestConsulRegistry(t, server.URL)
+	registry.token = ""
+	_, err := registry.Get(context.Background(), "/services")
+	require.NoError(t, err)
+
+	consul.delete("services/cas/two")
+	eventChannel := watch(t, registry, "/services", true)
+
+	event := receive(t, eventChannel)
+	assert.True(t, event.IsResync())
+}
+
+func TestConsulRegistry_WatchKey(t *testing.T) {
+	consul, server := newFakeConsul(t)
+	consul.put("config/_global/maintenance", "off")
+
+	registry := newTestConsulRegistry(t, server.URL)
+	registry.token = ""
+	_, err := registry.Get(context.Background(), "/config/_global/maintenance")
+	require.NoError(t, err)
+
+	eventChannel := watch(t, registry, "/config/_global/maintenance", false)
+
+	consul.put("config/_global/maintenance-x", "other")
+	consul.put("config/_global/maintenance", "on")
+
+	event := receive(t, eventChannel)
+	assert.Equal(t, "/config/_global/maintenance", event.Key())
+	assert.Equal(t, "on", event.Node.Value)
+	assertNoEvent(t, eventChannel)
+}
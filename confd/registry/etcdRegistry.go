@@ -1,94 +1,212 @@
 package registry
 
 import (
+	"context"
 	"log"
-	"strings"
+	"net"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.etcd.io/etcd/client/v2"
-	"golang.org/x/net/context"
 )
 
 // EtcdRegistry implements the Registry interface for etcd
 type EtcdRegistry struct {
 	keysAPI     client.KeysAPI
+	retryPolicy RetryPolicy
 	indexMutex  sync.Mutex
 	recentIndex uint64
 }
 
-// NewEtcdRegistry creates and configures a new EtcdRegistry
-func NewEtcdRegistry(config Config) (*EtcdRegistry, error) {
+// NewEtcdRegistry creates and configures a new EtcdRegistry, the synchronization of the endpoints stops when the
+// context is cancelled
+func NewEtcdRegistry(ctx context.Context, config Config) (*EtcdRegistry, error) {
+	tlsConfig, err := config.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := client.Config{
 		Endpoints: config.Endpoints,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   config.dialTimeout(),
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		},
+		Username:                config.Username,
+		Password:                config.Password,
+		HeaderTimeoutPerRequest: config.HeaderTimeout,
 	}
 	c, err := client.New(cfg)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "Could not create client:")
 	}
+
+	if interval := config.autoSyncInterval(); interval > 0 {
+		go autoSync(ctx, c, interval)
+	}
+
 	keysAPI := client.NewKeysAPI(c)
-	return &EtcdRegistry{keysAPI: keysAPI, recentIndex: 0}, nil
+	return &EtcdRegistry{keysAPI: keysAPI, retryPolicy: config.Retry, recentIndex: 0}, nil
+}
+
+// autoSync replaces the endpoints of the client with the client urls of the cluster members, so that the client
+// can switch to members which were not configured if a configured member is lost. In contrast to client.AutoSync a
+// failed synchronization does not stop the loop, the previous endpoints are kept until the next attempt.
+func autoSync(ctx context.Context, c client.Client, interval time.Duration) {
+	for ctx.Err() == nil {
+		syncCtx, cancel := context.WithTimeout(ctx, interval)
+		err := c.Sync(syncCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to synchronize etcd endpoints, keep %v: %v", c.Endpoints(), err)
+		}
+		sleep(ctx, interval)
+	}
 }
 
 // Get returns the value associated with the provided key
-func (r *EtcdRegistry) Get(key string) (*client.Response, error) {
-	resp, err := r.keysAPI.Get(context.Background(), key, nil)
+func (r *EtcdRegistry) Get(ctx context.Context, key string) (*Node, error) {
+	resp, err := r.keysAPI.Get(ctx, key, nil)
 
 	if err != nil {
-		// do not wrap this error because the error code will be checked
-		return nil, err
+		if client.IsKeyNotFound(err) {
+			return nil, &KeyNotFoundError{Key: key}
+		}
+		return nil, errors.Wrapf(err, "failed to read key %s", key)
 	}
 
 	r.updateIndexIfNecessary(resp.Index)
-	return resp, nil
+	return convertNode(resp.Node), nil
 }
 
-// We only update the recent index iff it is 0; which happens only in 2 cases:
-// 1. At startup
-// 2. In case of an error during watch
-// We do this, to not miss any changes made to etcd between
-// 1. Startup and starting the watcher
-// 2. An error and the restart of the watcher
-func (r *EtcdRegistry) updateIndexIfNecessary(index uint64) {
-	if r.recentIndex == 0 {
-		r.indexMutex.Lock()
-		defer r.indexMutex.Unlock()
-		if r.recentIndex == 0 {
-			r.recentIndex = index
+// GetTree returns the node of the key with all of its descendants, which are read with a single request
+func (r *EtcdRegistry) GetTree(ctx context.Context, key string) (*Node, error) {
+	resp, err := r.keysAPI.Get(ctx, key, &client.GetOptions{Recursive: true, Sort: true})
+
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil, &KeyNotFoundError{Key: key}
 		}
+		return nil, errors.Wrapf(err, "failed to read tree %s", key)
+	}
 
+	r.updateIndexIfNecessary(resp.Index)
+	return convertNode(resp.Node), nil
+}
+
+// updateIndexIfNecessary remembers the index of the first read. All watches start after this index, so that no
+// change between the first read and the start of a watch gets lost. Watches keep track of their own index afterwards.
+func (r *EtcdRegistry) updateIndexIfNecessary(index uint64) {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	if r.recentIndex == 0 {
+		r.recentIndex = index
 	}
 }
 
-// Watch watches for changes of the provided key and sends the event through the channel
-func (r *EtcdRegistry) Watch(key string, recursive bool, eventChannel chan *client.Response) {
+// startIndex returns the index after which new watches start
+func (r *EtcdRegistry) startIndex() uint64 {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	return r.recentIndex
+}
 
-	options := client.WatcherOptions{AfterIndex: r.recentIndex, Recursive: recursive}
-	watcher := r.keysAPI.Watcher(key, &options)
+// Watch watches for changes of the provided key and sends the event through the channel. A failed watch is restarted
+// at the index of the last received event after the delay of the retry policy. If etcd has already cleared the index
+// of the watch, the watch is restarted at the current index and a resync event is sent. Watch returns only if the
+// context is cancelled.
+func (r *EtcdRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	afterIndex := r.startIndex()
+	retry := newBackoff(r.retryPolicy)
+
+	watcher := r.keysAPI.Watcher(key, &client.WatcherOptions{AfterIndex: afterIndex, Recursive: recursive})
 	for {
-		resp, err := watcher.Next(context.Background())
+		resp, err := watcher.Next(ctx)
 
 		if err != nil {
-			if strings.Contains(err.Error(), "etcd cluster is unavailable or misconfigured") {
-				log.Printf("Cannot reach etcd cluster. Try again in 300 seconds. Error: %v", err)
-				r.indexMutex.Lock()
-				defer r.indexMutex.Unlock()
-				r.recentIndex = 0
-				time.Sleep(time.Minute * 5)
-				return
-			} else {
-				log.Printf("Could not get event. Try again in 30 seconds. Error: %v", err)
-				r.indexMutex.Lock()
-				defer r.indexMutex.Unlock()
-				r.recentIndex = 0
-				time.Sleep(time.Second * 30)
+			if ctx.Err() != nil {
 				return
 			}
+
+			class := classifyWatchError(err)
+			watchRetries.Add(class.String(), 1)
+
+			if class == watchErrorIndexCleared {
+				index, resyncErr := r.currentIndex(ctx, key)
+				if resyncErr == nil {
+					log.Printf("index %d of watch %s was cleared, restart watch at %d and resync: %v", afterIndex, key, index, err)
+					afterIndex = index
+					watcher = r.keysAPI.Watcher(key, &client.WatcherOptions{AfterIndex: afterIndex, Recursive: recursive})
+					if !send(ctx, eventChannel, &Event{Action: ActionResync, Node: &Node{Key: key, Dir: recursive}, Index: index}) {
+						return
+					}
+					continue
+				}
+				err = resyncErr
+			}
+
+			delay := retry.next()
+			log.Printf("watch of %s failed (%s), retry attempt %d in %v: %v", key, class, retry.attempt, delay, err)
+			sleep(ctx, delay)
+
+			watcher = r.keysAPI.Watcher(key, &client.WatcherOptions{AfterIndex: afterIndex, Recursive: recursive})
+			continue
 		}
 
-		eventChannel <- resp
+		retry.reset()
+		afterIndex = resp.Node.ModifiedIndex
+
+		if !send(ctx, eventChannel, convertResponse(resp)) {
+			return
+		}
 	}
 
 }
+
+// currentIndex reads the key recursively to get the current etcd index, a watch after this index does not miss any
+// change of the key
+func (r *EtcdRegistry) currentIndex(ctx context.Context, key string) (uint64, error) {
+	resp, err := r.keysAPI.Get(ctx, key, &client.GetOptions{Recursive: true})
+	if err != nil {
+		var etcdError client.Error
+		if errors.As(err, &etcdError) && etcdError.Code == client.ErrorCodeKeyNotFound {
+			return etcdError.Index, nil
+		}
+		return 0, errors.Wrapf(err, "failed to read current index of %s", key)
+	}
+	return resp.Index, nil
+}
+
+func convertResponse(resp *client.Response) *Event {
+	return &Event{
+		Action:   resp.Action,
+		Node:     convertNode(resp.Node),
+		PrevNode: convertNode(resp.PrevNode),
+		Index:    resp.Index,
+	}
+}
+
+func convertNode(node *client.Node) *Node {
+	if node == nil {
+		return nil
+	}
+
+	converted := &Node{
+		Key:           node.Key,
+		Value:         node.Value,
+		Dir:           node.Dir,
+		ModifiedIndex: node.ModifiedIndex,
+	}
+	for _, child := range node.Nodes {
+		converted.Nodes = append(converted.Nodes, convertNode(child))
+	}
+	return converted
+}
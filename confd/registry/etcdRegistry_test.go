@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/client/v2"
+)
+
+type watchResult struct {
+	resp *client.Response
+	err  error
+}
+
+type fakeWatcher struct {
+	results []watchResult
+}
+
+func (w *fakeWatcher) Next(ctx context.Context) (*client.Response, error) {
+	if len(w.results) == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	result := w.results[0]
+	w.results = w.results[1:]
+	return result.resp, result.err
+}
+
+type fakeKeysAPI struct {
+	client.KeysAPI
+	watchers   []*fakeWatcher
+	options    []client.WatcherOptions
+	getResp    *client.Response
+	getErr     error
+	getOptions *client.GetOptions
+}
+
+func (api *fakeKeysAPI) Get(_ context.Context, _ string, opts *client.GetOptions) (*client.Response, error) {
+	api.getOptions = opts
+	return api.getResp, api.getErr
+}
+
+func (api *fakeKeysAPI) Watcher(_ string, opts *client.WatcherOptions) client.Watcher {
+	api.options = append(api.options, *opts)
+	watcher := api.watchers[0]
+	api.watchers = api.watchers[1:]
+	return watcher
+}
+
+func createResponse(key string, index uint64) *client.Response {
+	return &client.Response{Action: "set", Node: &client.Node{Key: key, Value: "value", ModifiedIndex: index}, Index: index}
+}
+
+func TestEtcdRegistry_Watch(t *testing.T) {
+	fastRetry := RetryPolicy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	t.Run("should restart failed watch after last received index", func(t *testing.T) {
+		keysAPI := &fakeKeysAPI{watchers: []*fakeWatcher{
+			{results: []watchResult{{resp: createResponse("/services/a", 42)}, {err: &client.ClusterError{}}}},
+			{results: []watchResult{{resp: createResponse("/services/b", 43)}}},
+		}}
+		registry := &EtcdRegistry{keysAPI: keysAPI, retryPolicy: fastRetry, recentIndex: 21}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		eventChannel := make(chan *Event)
+		go registry.Watch(ctx, "/services", true, eventChannel)
+
+		assert.Equal(t, "/services/a", receive(t, eventChannel).Key())
+		assert.Equal(t, "/services/b", receive(t, eventChannel).Key())
+
+		require.Len(t, keysAPI.options, 2)
+		assert.Equal(t, uint64(21), keysAPI.options[0].AfterIndex)
+		assert.Equal(t, uint64(42), keysAPI.options[1].AfterIndex)
+		assert.True(t, keysAPI.options[1].Recursive)
+	})
+
+	t.Run("should restart watch at current index and resync if the index was cleared", func(t *testing.T) {
+		keysAPI := &fakeKeysAPI{
+			watchers: []*fakeWatcher{
+				{results: []watchResult{{err: client.Error{Code: client.ErrorCodeEventIndexCleared}}}},
+				{results: []watchResult{{resp: createResponse("/services/a", 2001)}}},
+			},
+			getResp: &client.Response{Action: "get", Node: &client.Node{Key: "/services", Dir: true}, Index: 2000},
+		}
+		registry := &EtcdRegistry{keysAPI: keysAPI, retryPolicy: fastRetry, recentIndex: 21}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		eventChannel := make(chan *Event)
+		go registry.Watch(ctx, "/services", true, eventChannel)
+
+		event := receive(t, eventChannel)
+		assert.True(t, event.IsResync())
+		assert.Equal(t, "/services", event.Key())
+		assert.Equal(t, "/services/a", receive(t, eventChannel).Key())
+
+		assert.True(t, keysAPI.getOptions.Recursive)
+		require.Len(t, keysAPI.options, 2)
+		assert.Equal(t, uint64(2000), keysAPI.options[1].AfterIndex)
+	})
+
+	t.Run("should use index of key not found error for resync", func(t *testing.T) {
+		keysAPI := &fakeKeysAPI{
+			watchers: []*fakeWatcher{
+				{results: []watchResult{{err: client.Error{Code: client.ErrorCodeEventIndexCleared}}}},
+				{},
+			},
+			getErr: client.Error{Code: client.ErrorCodeKeyNotFound, Index: 3000},
+		}
+		registry := &EtcdRegistry{keysAPI: keysAPI, retryPolicy: fastRetry, recentIndex: 21}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		eventChannel := make(chan *Event)
+		go registry.Watch(ctx, "/services", true, eventChannel)
+
+		assert.True(t, receive(t, eventChannel).IsResync())
+		require.Len(t, keysAPI.options, 2)
+		assert.Equal(t, uint64(3000), keysAPI.options[1].AfterIndex)
+	})
+}
+
+type fakeClient struct {
+	client.Client
+	syncs chan error
+	count int
+}
+
+func (c *fakeClient) Sync(context.Context) error {
+	c.count++
+	if c.count == 1 {
+		return assert.AnError
+	}
+	c.syncs <- nil
+	return nil
+}
+
+func (c *fakeClient) Endpoints() []string {
+	return []string{"http://localhost:2379"}
+}
+
+func TestAutoSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake := &fakeClient{syncs: make(chan error)}
+	go autoSync(ctx, fake, time.Millisecond)
+
+	// the loop continues after a failed synchronization
+	select {
+	case <-fake.syncs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("endpoints were not synchronized after a failure")
+	}
+}
+
+func TestConfig_autoSyncInterval(t *testing.T) {
+	single := []string{"http://etcd:2379"}
+	cluster := []string{"http://etcd-0:2379", "http://etcd-1:2379"}
+
+	assert.Equal(t, time.Duration(0), Config{Endpoints: single}.autoSyncInterval(), "disabled by default for a single endpoint")
+	assert.Equal(t, DefaultAutoSyncInterval, Config{Endpoints: cluster}.autoSyncInterval())
+	assert.Equal(t, time.Minute, Config{Endpoints: single, AutoSyncInterval: time.Minute}.autoSyncInterval())
+	assert.Equal(t, time.Duration(0), Config{Endpoints: cluster, AutoSyncInterval: -1}.autoSyncInterval())
+}
@@ -0,0 +1,334 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdV3Registry implements the Registry interface on top of the etcd v3 kv and watch api. The flat v3 keyspace is
+// mapped onto the directory style keys of the v2 api: every key which is a prefix of other keys (separated by a
+// slash) is treated as a directory.
+type EtcdV3Registry struct {
+	client        *clientv3.Client
+	retryPolicy   RetryPolicy
+	headerTimeout time.Duration
+	indexMutex    sync.Mutex
+	recentIndex   uint64
+}
+
+// NewEtcdV3Registry creates and configures a new EtcdV3Registry, the client is closed when the context is cancelled
+func NewEtcdV3Registry(ctx context.Context, config Config) (*EtcdV3Registry, error) {
+	tlsConfig, err := config.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := clientv3.Config{
+		Context:          ctx,
+		Endpoints:        config.Endpoints,
+		AutoSyncInterval: config.autoSyncInterval(),
+		DialTimeout:      config.dialTimeout(),
+		TLS:              tlsConfig,
+		Username:         config.Username,
+		Password:         config.Password,
+	}
+	c, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create v3 client:")
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := c.Close(); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("failed to close etcd v3 client: %v", err)
+		}
+	}()
+
+	return &EtcdV3Registry{client: c, retryPolicy: config.Retry, headerTimeout: config.HeaderTimeout, recentIndex: 0}, nil
+}
+
+// Get returns the value associated with the provided key. If the key is a directory, the response contains the
+// direct children of the directory, like a non recursive get of the v2 api.
+func (r *EtcdV3Registry) Get(ctx context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	ctx, cancel := r.requestContext(ctx)
+	defer cancel()
+
+	resp, err := r.client.Get(ctx, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key %s", key)
+	}
+
+	if len(resp.Kvs) > 0 {
+		kv := resp.Kvs[0]
+		r.updateIndexIfNecessary(uint64(resp.Header.Revision))
+		return &Node{Key: key, Value: string(kv.Value), ModifiedIndex: uint64(kv.ModRevision)}, nil
+	}
+
+	prefix := directoryPrefix(key)
+	resp, err = r.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read directory %s", key)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+
+	r.updateIndexIfNecessary(uint64(resp.Header.Revision))
+	return &Node{Key: key, Dir: true, Nodes: directChildren(prefix, kvNodes(resp.Kvs))}, nil
+}
+
+// GetTree returns the node of the key with all of its descendants, which are read with a single request. Like Get a
+// key with a value is returned as value, even if there are keys below it.
+func (r *EtcdV3Registry) GetTree(ctx context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	ctx, cancel := r.requestContext(ctx)
+	defer cancel()
+
+	resp, err := r.client.Get(ctx, key, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read tree %s", key)
+	}
+
+	prefix := directoryPrefix(key)
+	root := &Node{Key: key, Dir: true}
+	found := false
+	for _, node := range kvNodes(resp.Kvs) {
+		if node.Key == key {
+			r.updateIndexIfNecessary(uint64(resp.Header.Revision))
+			return node, nil
+		}
+
+		if strings.HasPrefix(node.Key, prefix) {
+			insertNode(root, prefix, node)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+
+	r.updateIndexIfNecessary(uint64(resp.Header.Revision))
+	return root, nil
+}
+
+// requestContext limits the context of a read to the header timeout
+func (r *EtcdV3Registry) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.headerTimeout > 0 {
+		return context.WithTimeout(ctx, r.headerTimeout)
+	}
+	return ctx, func() {}
+}
+
+// updateIndexIfNecessary remembers the revision of the first read as the start of all watches
+func (r *EtcdV3Registry) updateIndexIfNecessary(index uint64) {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	if r.recentIndex == 0 {
+		r.recentIndex = index
+	}
+}
+
+// startIndex returns the revision after which new watches start
+func (r *EtcdV3Registry) startIndex() uint64 {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	return r.recentIndex
+}
+
+// Watch watches for changes of the provided key and sends the event through the channel. A failed watch is restarted
+// after the revision of the last received event after the delay of the retry policy. If the revision of the watch
+// was already compacted, the watch is restarted at the current revision and a resync event is sent. Watch returns
+// only if the context is cancelled.
+func (r *EtcdV3Registry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+	revision := int64(r.startIndex())
+	retry := newBackoff(r.retryPolicy)
+
+	for {
+		lastRevision, err := r.watch(ctx, key, recursive, revision, eventChannel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if lastRevision > revision {
+			revision = lastRevision
+			retry.reset()
+		}
+
+		class := classifyWatchError(err)
+		watchRetries.Add(class.String(), 1)
+
+		if class == watchErrorIndexCleared {
+			current, resyncErr := r.currentRevision(ctx, key)
+			if resyncErr == nil {
+				log.Printf("revision %d of watch %s was compacted, restart watch at %d and resync: %v", revision, key, current, err)
+				revision = current
+				if !send(ctx, eventChannel, &Event{Action: ActionResync, Node: &Node{Key: key, Dir: recursive}, Index: uint64(current)}) {
+					return
+				}
+				continue
+			}
+			err = resyncErr
+		}
+
+		delay := retry.next()
+		log.Printf("watch of %s failed (%s), retry attempt %d in %v: %v", key, class, retry.attempt, delay, err)
+		sleep(ctx, delay)
+	}
+}
+
+// watch delivers the events after the revision until the watch fails and returns the revision of the last event
+func (r *EtcdV3Registry) watch(ctx context.Context, key string, recursive bool, revision int64, eventChannel chan *Event) (int64, error) {
+	options := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if recursive {
+		options = append(options, clientv3.WithPrefix())
+	}
+	if revision > 0 {
+		options = append(options, clientv3.WithRev(revision+1))
+	}
+
+	// the watch channel of the client is only closed, if the context of the watch is cancelled
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	for watchResp := range r.client.Watch(watchCtx, key, options...) {
+		if err := watchResp.Err(); err != nil {
+			return revision, err
+		}
+
+		for _, event := range watchResp.Events {
+			eventKey := string(event.Kv.Key)
+			// the prefix watch matches also siblings with the same prefix e.g. /servicesX for /services
+			if recursive && eventKey != key && !strings.HasPrefix(eventKey, directoryPrefix(key)) {
+				continue
+			}
+
+			if !send(ctx, eventChannel, convertV3Event(event, uint64(watchResp.Header.Revision))) {
+				return revision, ctx.Err()
+			}
+			revision = event.Kv.ModRevision
+		}
+	}
+
+	return revision, errors.New("watch channel was closed")
+}
+
+// currentRevision returns the current revision of the store, a watch after this revision does not miss any change
+// of the key
+func (r *EtcdV3Registry) currentRevision(ctx context.Context, key string) (int64, error) {
+	resp, err := r.client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read current revision of %s", key)
+	}
+	return resp.Header.Revision, nil
+}
+
+func convertV3Event(event *clientv3.Event, index uint64) *Event {
+	converted := &Event{Index: index}
+
+	kv := event.Kv
+	node := &Node{Key: string(kv.Key), ModifiedIndex: uint64(kv.ModRevision)}
+	switch {
+	case event.Type == clientv3.EventTypeDelete:
+		converted.Action = ActionDelete
+	case event.IsCreate():
+		converted.Action = ActionCreate
+		node.Value = string(kv.Value)
+	default:
+		converted.Action = ActionSet
+		node.Value = string(kv.Value)
+	}
+	converted.Node = node
+
+	if event.PrevKv != nil {
+		prev := event.PrevKv
+		converted.PrevNode = &Node{Key: string(prev.Key), Value: string(prev.Value), ModifiedIndex: uint64(prev.ModRevision)}
+	}
+
+	return converted
+}
+
+// directChildren creates the child nodes of a directory from all nodes below the directory prefix, the nodes have to
+// be sorted by their keys. Keys which are nested deeper than one level are collapsed to a single directory node.
+func directChildren(prefix string, nodes Nodes) Nodes {
+	children := Nodes{}
+	var lastDir string
+	for _, node := range nodes {
+		rest := strings.TrimPrefix(node.Key, prefix)
+		if rest == "" {
+			continue
+		}
+
+		// empty directories are stored as nodes by some backends
+		if node.Dir {
+			if node.Key != lastDir {
+				children = append(children, node)
+				lastDir = node.Key
+			}
+			continue
+		}
+
+		if idx := strings.Index(rest, "/"); idx >= 0 {
+			dir := prefix + rest[:idx]
+			if dir != lastDir {
+				children = append(children, &Node{Key: dir, Dir: true})
+				lastDir = dir
+			}
+			continue
+		}
+
+		children = append(children, node)
+	}
+	return children
+}
+
+// insertNode adds the node to the tree below the directory and creates the missing directories. The nodes have to be
+// inserted in the order of their keys, because only the last child of a directory is compared.
+func insertNode(dir *Node, prefix string, node *Node) {
+	segments := strings.Split(strings.TrimPrefix(node.Key, prefix), "/")
+	for _, segment := range segments[:len(segments)-1] {
+		key := path.Join(dir.Key, segment)
+		last := len(dir.Nodes) - 1
+		if last < 0 || dir.Nodes[last].Key != key || !dir.Nodes[last].Dir {
+			dir.Nodes = append(dir.Nodes, &Node{Key: key, Dir: true})
+			last++
+		}
+		dir = dir.Nodes[last]
+	}
+	dir.Nodes = append(dir.Nodes, node)
+}
+
+// kvNodes converts the key values of a response into nodes
+func kvNodes(kvs []*mvccpb.KeyValue) Nodes {
+	nodes := make(Nodes, 0, len(kvs))
+	for _, kv := range kvs {
+		nodes = append(nodes, &Node{Key: string(kv.Key), Value: string(kv.Value), ModifiedIndex: uint64(kv.ModRevision)})
+	}
+	return nodes
+}
+
+// normalizeKey converts a key to an absolute key without a trailing slash, because the v2 api accepts keys with and
+// without a leading slash e.g. config/nginx and /config/nginx
+func normalizeKey(key string) string {
+	return "/" + strings.Trim(key, "/")
+}
+
+func directoryPrefix(key string) string {
+	if key == "/" {
+		return key
+	}
+	return key + "/"
+}
@@ -0,0 +1,235 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/server/v3/embed"
+	"google.golang.org/grpc/connectivity"
+)
+
+func startEmbeddedEtcd(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+
+	clientURL := url.URL{Scheme: "http", Host: freeAddress(t)}
+	peerURL := url.URL{Scheme: "http", Host: freeAddress(t)}
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("embedded etcd did not start in time")
+	}
+
+	return clientURL.String()
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func newTestEtcdV3Registry(t *testing.T) *EtcdV3Registry {
+	endpoint := startEmbeddedEtcd(t)
+	registry, err := NewEtcdV3Registry(context.Background(), Config{Endpoints: []string{endpoint}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		registry.client.Close()
+	})
+	return registry
+}
+
+func put(t *testing.T, registry *EtcdV3Registry, key string, value string) {
+	_, err := registry.client.Put(context.Background(), key, value)
+	require.NoError(t, err)
+}
+
+func TestEtcdV3Registry_Get(t *testing.T) {
+	registry := newTestEtcdV3Registry(t)
+	put(t, registry, "/services/nginx/one", "{\"name\": \"nginx\"}")
+	put(t, registry, "/services/cas/one", "{\"name\": \"cas\"}")
+	put(t, registry, "/services/cas/two", "{\"name\": \"cas\"}")
+	put(t, registry, "/servicesX/other", "other")
+	put(t, registry, "/config/_global/maintenance", "{\"title\": \"Maintenance\"}")
+
+	t.Run("should return value of key", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/config/_global/maintenance")
+		require.NoError(t, err)
+		assert.False(t, node.Dir)
+		assert.Equal(t, "{\"title\": \"Maintenance\"}", node.Value)
+	})
+
+	t.Run("should accept keys without leading slash", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "config/_global/maintenance")
+		require.NoError(t, err)
+		assert.Equal(t, "/config/_global/maintenance", node.Key)
+	})
+
+	t.Run("should return direct children of directory", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services")
+		require.NoError(t, err)
+		assert.True(t, node.Dir)
+		require.Len(t, node.Nodes, 2)
+		assert.Equal(t, "/services/cas", node.Nodes[0].Key)
+		assert.True(t, node.Nodes[0].Dir)
+		assert.Equal(t, "/services/nginx", node.Nodes[1].Key)
+	})
+
+	t.Run("should return values of children", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services/cas")
+		require.NoError(t, err)
+		require.Len(t, node.Nodes, 2)
+		assert.Equal(t, "/services/cas/one", node.Nodes[0].Key)
+		assert.Equal(t, "{\"name\": \"cas\"}", node.Nodes[0].Value)
+	})
+
+	t.Run("should return key not found error", func(t *testing.T) {
+		_, err := registry.Get(context.Background(), "/dogu")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFound(err))
+	})
+
+	t.Run("should return whole tree", func(t *testing.T) {
+		node, err := registry.GetTree(context.Background(), "/services")
+		require.NoError(t, err)
+		require.Len(t, node.Nodes, 2)
+		assert.Equal(t, "/services/cas", node.Nodes[0].Key)
+		require.Len(t, node.Nodes[0].Nodes, 2)
+		assert.Equal(t, "/services/cas/two", node.Nodes[0].Nodes[1].Key)
+		assert.Equal(t, "{\"name\": \"cas\"}", node.Nodes[0].Nodes[1].Value)
+		require.Len(t, node.Nodes[1].Nodes, 1)
+		assert.Equal(t, "/services/nginx/one", node.Nodes[1].Nodes[0].Key)
+	})
+
+	t.Run("should return key not found error for missing tree", func(t *testing.T) {
+		_, err := registry.GetTree(context.Background(), "/dogu")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFound(err))
+	})
+}
+
+func TestEtcdV3Registry_Watch(t *testing.T) {
+	registry := newTestEtcdV3Registry(t)
+	put(t, registry, "/services/cas/one", "one")
+
+	_, err := registry.Get(context.Background(), "/services")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventChannel := make(chan *Event)
+	// the watch starts after the index of the get, so no event gets lost between get and watch
+	go registry.Watch(ctx, "/services", true, eventChannel)
+
+	put(t, registry, "/servicesX/other", "other")
+	put(t, registry, "/services/cas/one", "updated")
+	put(t, registry, "/services/nginx/one", "created")
+	_, err = registry.client.Delete(context.Background(), "/services/cas/one")
+	require.NoError(t, err)
+
+	event := receive(t, eventChannel)
+	assert.Equal(t, "set", event.Action)
+	assert.Equal(t, "/services/cas/one", event.Node.Key)
+	assert.Equal(t, "updated", event.Node.Value)
+	assert.Equal(t, "one", event.PrevNode.Value)
+
+	event = receive(t, eventChannel)
+	assert.Equal(t, "create", event.Action)
+	assert.Equal(t, "/services/nginx/one", event.Node.Key)
+
+	event = receive(t, eventChannel)
+	assert.Equal(t, "delete", event.Action)
+	assert.Equal(t, "/services/cas/one", event.Node.Key)
+	assert.Equal(t, "updated", event.PrevNode.Value)
+}
+
+func TestEtcdV3Registry_WatchCompacted(t *testing.T) {
+	registry := newTestEtcdV3Registry(t)
+	put(t, registry, "/services/cas/one", "one")
+
+	_, err := registry.Get(context.Background(), "/services")
+	require.NoError(t, err)
+
+	// the compaction must be newer than the revision after the get
+	put(t, registry, "/services/cas/one", "two")
+	put(t, registry, "/other", "other")
+	resp, err := registry.client.Get(context.Background(), "/other")
+	require.NoError(t, err)
+	_, err = registry.client.Compact(context.Background(), resp.Header.Revision)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventChannel := make(chan *Event)
+	go registry.Watch(ctx, "/services", true, eventChannel)
+
+	event := receive(t, eventChannel)
+	assert.True(t, event.IsResync())
+	assert.Equal(t, "/services", event.Key())
+
+	put(t, registry, "/services/cas/one", "three")
+	event = receive(t, eventChannel)
+	assert.Equal(t, "three", event.Node.Value)
+}
+
+func TestEtcdV3Registry_WatchCancel(t *testing.T) {
+	registry := newTestEtcdV3Registry(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Watch(ctx, "/services", true, make(chan *Event))
+		close(done)
+	}()
+
+	put(t, registry, "/services/cas/one", "one")
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch did not return after the context was cancelled")
+	}
+}
+
+func receive(t *testing.T, eventChannel chan *Event) *Event {
+	select {
+	case event := <-eventChannel:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not receive event in time")
+	}
+	return nil
+}
+
+func TestNewEtcdV3Registry_close(t *testing.T) {
+	endpoint := startEmbeddedEtcd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	registry, err := NewEtcdV3Registry(ctx, Config{Endpoints: []string{endpoint}})
+	require.NoError(t, err)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return registry.client.ActiveConnection().GetState() == connectivity.Shutdown
+	}, 5*time.Second, 10*time.Millisecond, "client was not closed after the context was cancelled")
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// eventLog keeps the recent events of a registry in the order of their sequence numbers, so that a watch can start
+// at a point in the past. The log is not synchronized, the owner guards it with its own mutex.
+type eventLog struct {
+	// limit is the maximum number of kept events, zero keeps all events
+	limit   int
+	entries []loggedEvent
+	seq     uint64
+	// changed is closed and replaced on every append to wake up the watches
+	changed chan struct{}
+	// reads contains the sequence number of the first read of each key
+	reads map[string]uint64
+}
+
+type loggedEvent struct {
+	seq   uint64
+	event *Event
+}
+
+func newEventLog(limit int) *eventLog {
+	return &eventLog{limit: limit, changed: make(chan struct{}), reads: map[string]uint64{}}
+}
+
+// markRead remembers the sequence number of the state which was read as the first read of the key
+func (l *eventLog) markRead(key string, seq uint64) {
+	key = normalizeKey(key)
+	if _, read := l.reads[key]; !read {
+		l.reads[key] = seq
+	}
+}
+
+// start returns the sequence number after which a watch of the key starts, which is the first read of the key or
+// the current sequence number, if the key was not read before
+func (l *eventLog) start(key string) uint64 {
+	seq, read := l.reads[normalizeKey(key)]
+	if !read {
+		return l.seq
+	}
+	return seq
+}
+
+// append adds the event with the next sequence number and drops the oldest event, if the limit is reached
+func (l *eventLog) append(event *Event) uint64 {
+	l.seq++
+	l.entries = append(l.entries, loggedEvent{seq: l.seq, event: event})
+	if l.limit > 0 && len(l.entries) > l.limit {
+		l.entries = append([]loggedEvent(nil), l.entries[len(l.entries)-l.limit:]...)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return l.seq
+}
+
+// after returns copies of the events after the sequence number which concern the key. The result is incomplete, if
+// events after the sequence number were already dropped.
+func (l *eventLog) after(seq uint64, key string, recursive bool) ([]*Event, bool) {
+	complete := seq >= l.seq || (len(l.entries) > 0 && l.entries[0].seq <= seq+1)
+
+	start := sort.Search(len(l.entries), func(i int) bool {
+		return l.entries[i].seq > seq
+	})
+
+	var events []*Event
+	for _, entry := range l.entries[start:] {
+		if concerns(entry.event, key, recursive) {
+			// the copy protects the log against modifications of the consumers
+			events = append(events, copyEvent(entry.event))
+		}
+	}
+	return events, complete
+}
+
+// follow sends the events after the sequence number which concern the key to the channel, until the context is
+// cancelled. A resync event is sent, if the log has already dropped some of the events.
+func (l *eventLog) follow(ctx context.Context, mutex *sync.Mutex, seq uint64, key string, recursive bool, eventChannel chan *Event) {
+	for {
+		mutex.Lock()
+		events, complete := l.after(seq, key, recursive)
+		changed := l.changed
+		seq = l.seq
+		mutex.Unlock()
+
+		if !complete {
+			events = append([]*Event{{Action: ActionResync, Node: &Node{Key: key, Dir: recursive}}}, events...)
+		}
+
+		for _, event := range events {
+			if !send(ctx, eventChannel, event) {
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// concerns returns true if the event has to be sent to a watch of the key. The delete of a directory and a resync
+// concern all keys below their key.
+func concerns(event *Event, key string, recursive bool) bool {
+	changed := event.Key()
+	switch {
+	case changed == key:
+		return true
+	case recursive && strings.HasPrefix(changed, directoryPrefix(key)):
+		return true
+	case event.IsResync() || (event.Action == ActionDelete && event.Node.Dir):
+		return strings.HasPrefix(key, directoryPrefix(changed))
+	}
+	return false
+}
@@ -0,0 +1,406 @@
+package registry
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// FileRegistry implements the Registry interface on top of a directory tree, e.g. the file
+// <directory>/services/cas/one contains the value of the key /services/cas/one. Directories are mapped to directory
+// nodes and files to values. Hidden files and editor backups (ending with a tilde) are ignored and a trailing newline
+// is removed from the values, so that the tree can be edited with any text editor.
+type FileRegistry struct {
+	directory   string
+	retryPolicy RetryPolicy
+	index       atomic.Uint64
+}
+
+// NewFileRegistry creates a new FileRegistry for the directory of the configuration
+func NewFileRegistry(config Config) (*FileRegistry, error) {
+	if config.Directory == "" {
+		return nil, errors.New("file registry requires a directory")
+	}
+
+	directory, err := filepath.Abs(config.Directory)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve directory %s", config.Directory)
+	}
+
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read directory %s", directory)
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", directory)
+	}
+
+	return &FileRegistry{directory: directory, retryPolicy: config.Retry}, nil
+}
+
+// Get returns the value associated with the provided key. If the key is a directory, the response contains the
+// direct children of the directory.
+func (r *FileRegistry) Get(_ context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+	file, err := r.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &KeyNotFoundError{Key: key}
+		}
+		return nil, errors.Wrapf(err, "failed to read key %s", key)
+	}
+
+	if !info.IsDir() {
+		value, err := readValue(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read key %s", key)
+		}
+		return &Node{Key: key, Value: value}, nil
+	}
+
+	entries, err := os.ReadDir(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key %s", key)
+	}
+
+	node := &Node{Key: key, Dir: true, Nodes: Nodes{}}
+	for _, entry := range entries {
+		if isIgnoredFile(entry.Name()) {
+			continue
+		}
+
+		childKey := path.Join(key, entry.Name())
+		if entry.IsDir() {
+			node.Nodes = append(node.Nodes, &Node{Key: childKey, Dir: true})
+			continue
+		}
+
+		value, err := readValue(filepath.Join(file, entry.Name()))
+		if err != nil {
+			// the file was removed after the directory was read
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to read key %s", childKey)
+		}
+		node.Nodes = append(node.Nodes, &Node{Key: childKey, Value: value})
+	}
+	return node, nil
+}
+
+// Watch watches for changes of the provided key and sends the event through the channel. The watch fails if the
+// parent directory of the key does not exist or is removed, it is restarted after the delay of the retry policy and
+// a resync event is sent, because changes may have been missed in the meantime. Watch returns only if the context is
+// cancelled, a key outside of the directory is never watched.
+func (r *FileRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+	if _, err := r.path(key); err != nil {
+		log.Printf("can not watch %s: %v", key, err)
+		<-ctx.Done()
+		return
+	}
+
+	retry := newBackoff(r.retryPolicy)
+	resync := false
+
+	for {
+		err := r.watch(ctx, key, recursive, resync, retry, eventChannel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		watchRetries.Add(classifyWatchError(err).String(), 1)
+		delay := retry.next()
+		log.Printf("watch of %s failed, retry in %s: %v", key, delay, err)
+		sleep(ctx, delay)
+		resync = true
+	}
+}
+
+func (r *FileRegistry) watch(ctx context.Context, key string, recursive bool, resync bool, retry *backoff, eventChannel chan *Event) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create file watcher")
+	}
+	defer watcher.Close()
+
+	// the parent is watched, because the key itself may be a file which is replaced or a directory which is created
+	// after the watch has started
+	file, err := r.path(key)
+	if err != nil {
+		return err
+	}
+	parent := r.directory
+	if key != "/" {
+		parent = filepath.Dir(file)
+	}
+	err = watcher.Add(parent)
+	if err != nil {
+		return errors.Wrapf(err, "failed to watch %s", parent)
+	}
+
+	state := &fileWatch{registry: r, watcher: watcher, key: key, recursive: recursive, values: map[string]string{}, dirs: map[string]bool{}}
+	_, err = state.load(file)
+	if err != nil {
+		return err
+	}
+	retry.reset()
+
+	if resync && !send(ctx, eventChannel, &Event{Action: ActionResync, Node: &Node{Key: key, Dir: recursive}, Index: r.index.Add(1)}) {
+		return ctx.Err()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("file watcher was closed")
+			}
+			return errors.Wrap(err, "file watcher failed")
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("file watcher was closed")
+			}
+
+			if event.Name == parent && event.Has(fsnotify.Remove|fsnotify.Rename) {
+				return errors.Errorf("watched directory %s was removed", parent)
+			}
+
+			for _, converted := range state.convert(event) {
+				if !send(ctx, eventChannel, converted) {
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// path returns the file of the key
+func (r *FileRegistry) path(key string) (string, error) {
+	file := filepath.Join(r.directory, filepath.FromSlash(normalizeKey(key)))
+	rel, err := filepath.Rel(r.directory, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("key %s is outside of the directory %s", key, r.directory)
+	}
+	return file, nil
+}
+
+// key returns the key of the file
+func (r *FileRegistry) key(file string) string {
+	rel, err := filepath.Rel(r.directory, file)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return normalizeKey(filepath.ToSlash(rel))
+}
+
+// fileWatch keeps the known values of a single watch, so that the events can carry the previous values and
+// unchanged writes can be dropped
+type fileWatch struct {
+	registry  *FileRegistry
+	watcher   *fsnotify.Watcher
+	key       string
+	recursive bool
+	values    map[string]string
+	dirs      map[string]bool
+}
+
+// inScope returns true if changes of the key are reported by the watch
+func (w *fileWatch) inScope(key string) bool {
+	if key == w.key {
+		return true
+	}
+	return w.recursive && strings.HasPrefix(key, directoryPrefix(w.key))
+}
+
+// load reads the current values below the file and watches all directories, if the watch is recursive. It returns
+// the keys which were not known before.
+func (w *fileWatch) load(file string) ([]string, error) {
+	var added []string
+	err := filepath.WalkDir(file, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// the file does not exist (yet) or was removed during the walk
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if current != file && isIgnoredFile(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		key := w.registry.key(current)
+		if !w.inScope(key) {
+			return nil
+		}
+
+		if entry.IsDir() {
+			if !w.dirs[key] {
+				w.dirs[key] = true
+				added = append(added, key)
+			}
+			if !w.recursive {
+				return filepath.SkipDir
+			}
+			return errors.Wrapf(w.watcher.Add(current), "failed to watch %s", current)
+		}
+
+		if _, known := w.values[key]; !known {
+			value, err := readValue(current)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return errors.Wrapf(err, "failed to read key %s", key)
+			}
+			w.values[key] = value
+			added = append(added, key)
+		}
+		return nil
+	})
+	return added, err
+}
+
+// convert translates the file system event into registry events
+func (w *fileWatch) convert(event fsnotify.Event) []*Event {
+	if isIgnoredFile(filepath.Base(event.Name)) {
+		return nil
+	}
+
+	key := w.registry.key(event.Name)
+	if !w.inScope(key) {
+		return nil
+	}
+
+	switch {
+	case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+		return w.changed(event.Name, key)
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		return w.removed(event.Name, key)
+	}
+	return nil
+}
+
+func (w *fileWatch) changed(file string, key string) []*Event {
+	info, err := os.Stat(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read key %s: %v", key, err)
+		}
+		return nil
+	}
+
+	if info.IsDir() {
+		// the files of a new directory may have been created before the directory was watched
+		added, err := w.load(file)
+		if err != nil {
+			log.Printf("failed to read directory %s: %v", key, err)
+		}
+
+		var events []*Event
+		for _, addedKey := range added {
+			if w.dirs[addedKey] {
+				events = append(events, w.event(ActionCreate, &Node{Key: addedKey, Dir: true}, nil))
+				continue
+			}
+			events = append(events, w.event(ActionCreate, &Node{Key: addedKey, Value: w.values[addedKey]}, nil))
+		}
+		return events
+	}
+
+	value, err := readValue(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read key %s: %v", key, err)
+		}
+		return nil
+	}
+
+	prev, known := w.values[key]
+	if known && prev == value {
+		return nil
+	}
+	w.values[key] = value
+
+	if !known {
+		return []*Event{w.event(ActionCreate, &Node{Key: key, Value: value}, nil)}
+	}
+	return []*Event{w.event(ActionSet, &Node{Key: key, Value: value}, &Node{Key: key, Value: prev})}
+}
+
+func (w *fileWatch) removed(file string, key string) []*Event {
+	prefix := directoryPrefix(key)
+
+	var keys []string
+	for known := range w.values {
+		if known == key || strings.HasPrefix(known, prefix) {
+			keys = append(keys, known)
+		}
+	}
+	sort.Strings(keys)
+
+	var events []*Event
+	for _, removed := range keys {
+		prev := w.values[removed]
+		delete(w.values, removed)
+		events = append(events, w.event(ActionDelete, &Node{Key: removed}, &Node{Key: removed, Value: prev}))
+	}
+
+	if w.dirs[key] {
+		for dir := range w.dirs {
+			if dir == key || strings.HasPrefix(dir, prefix) {
+				delete(w.dirs, dir)
+			}
+		}
+
+		// a renamed directory is still watched by inotify
+		for _, watched := range w.watcher.WatchList() {
+			if watched == file || strings.HasPrefix(watched, file+string(filepath.Separator)) {
+				_ = w.watcher.Remove(watched)
+			}
+		}
+		events = append(events, w.event(ActionDelete, &Node{Key: key, Dir: true}, &Node{Key: key, Dir: true}))
+	}
+	return events
+}
+
+func (w *fileWatch) event(action string, node *Node, prevNode *Node) *Event {
+	index := w.registry.index.Add(1)
+	node.ModifiedIndex = index
+	return &Event{Action: action, Node: node, PrevNode: prevNode, Index: index}
+}
+
+// readValue reads the file and removes a trailing newline
+func readValue(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// isIgnoredFile returns true for hidden files and editor backups
+func isIgnoredFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~")
+}
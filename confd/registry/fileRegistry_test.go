@@ -0,0 +1,222 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileRegistry(t *testing.T) *FileRegistry {
+	registry, err := NewFileRegistry(Config{
+		Directory: t.TempDir(),
+		Retry:     RetryPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	return registry
+}
+
+func keyPath(t *testing.T, registry *FileRegistry, key string) string {
+	file, err := registry.path(key)
+	require.NoError(t, err)
+	return file
+}
+
+func writeKey(t *testing.T, registry *FileRegistry, key string, value string) {
+	file := keyPath(t, registry, key)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	require.NoError(t, os.WriteFile(file, []byte(value), 0644))
+}
+
+// watcherStartup gives the watcher time to register the directories
+const watcherStartup = 100 * time.Millisecond
+
+func TestNewFileRegistry(t *testing.T) {
+	_, err := NewFileRegistry(Config{})
+	assert.Error(t, err)
+
+	_, err = NewFileRegistry(Config{Directory: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+
+	registry, err := New(context.Background(), Config{Backend: BackendFile, Directory: t.TempDir()})
+	require.NoError(t, err)
+	assert.IsType(t, &FileRegistry{}, registry)
+}
+
+func TestFileRegistry_Get(t *testing.T) {
+	registry := newTestFileRegistry(t)
+	writeKey(t, registry, "/services/nginx/one", "{\"name\": \"nginx\"}\n")
+	writeKey(t, registry, "/services/cas/one", "{\"name\": \"cas\"}")
+	writeKey(t, registry, "/services/cas/.one.swp", "swap")
+	writeKey(t, registry, "/services/cas/one~", "backup")
+	writeKey(t, registry, "/config/_global/maintenance", "true\r\n")
+
+	t.Run("should return value without trailing newline", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/config/_global/maintenance")
+		require.NoError(t, err)
+		assert.False(t, node.Dir)
+		assert.Equal(t, "true", node.Value)
+	})
+
+	t.Run("should accept keys without leading slash", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "config/_global/maintenance/")
+		require.NoError(t, err)
+		assert.Equal(t, "/config/_global/maintenance", node.Key)
+	})
+
+	t.Run("should return direct children of directory", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services")
+		require.NoError(t, err)
+		assert.True(t, node.Dir)
+		require.Len(t, node.Nodes, 2)
+		assert.Equal(t, "/services/cas", node.Nodes[0].Key)
+		assert.True(t, node.Nodes[0].Dir)
+		assert.Equal(t, "/services/nginx", node.Nodes[1].Key)
+	})
+
+	t.Run("should return values of children and ignore hidden files and backups", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services/cas")
+		require.NoError(t, err)
+		require.Len(t, node.Nodes, 1)
+		assert.Equal(t, "/services/cas/one", node.Nodes[0].Key)
+		assert.Equal(t, "{\"name\": \"cas\"}", node.Nodes[0].Value)
+	})
+
+	t.Run("should return key not found error", func(t *testing.T) {
+		_, err := registry.Get(context.Background(), "/dogu")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFound(err))
+	})
+}
+
+func TestFileRegistry_Watch(t *testing.T) {
+	registry := newTestFileRegistry(t)
+	writeKey(t, registry, "/services/cas/one", "one")
+	writeKey(t, registry, "/servicesX/other", "other")
+
+	eventChannel := watch(t, registry, "/services", true)
+	time.Sleep(watcherStartup)
+
+	t.Run("should send set event with previous value", func(t *testing.T) {
+		writeKey(t, registry, "/servicesX/other", "changed")
+		writeKey(t, registry, "/services/cas/one", "updated")
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionSet, event.Action)
+		assert.Equal(t, "/services/cas/one", event.Key())
+		assert.Equal(t, "updated", event.Node.Value)
+		assert.Equal(t, "one", event.PrevValue())
+	})
+
+	t.Run("should send create events for new directory", func(t *testing.T) {
+		writeKey(t, registry, "/services/nginx/one", "created")
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionCreate, event.Action)
+		assert.Equal(t, "/services/nginx", event.Key())
+		assert.True(t, event.Node.Dir)
+
+		event = receive(t, eventChannel)
+		assert.Equal(t, ActionCreate, event.Action)
+		assert.Equal(t, "/services/nginx/one", event.Key())
+		assert.Equal(t, "created", event.Node.Value)
+	})
+
+	t.Run("should ignore writes without changes", func(t *testing.T) {
+		writeKey(t, registry, "/services/nginx/one", "created")
+		writeKey(t, registry, "/services/nginx/two", "two")
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionCreate, event.Action)
+		assert.Equal(t, "/services/nginx/two", event.Key())
+	})
+
+	t.Run("should send delete events for removed directory", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(keyPath(t, registry, "/services/nginx")))
+
+		var keys []string
+		for len(keys) < 3 {
+			event := receive(t, eventChannel)
+			assert.Equal(t, ActionDelete, event.Action)
+			keys = append(keys, event.Key())
+		}
+		assert.ElementsMatch(t, []string{"/services/nginx/one", "/services/nginx/two", "/services/nginx"}, keys)
+	})
+}
+
+func TestFileRegistry_WatchKey(t *testing.T) {
+	registry := newTestFileRegistry(t)
+	writeKey(t, registry, "/config/_global/maintenance", "one")
+
+	eventChannel := watch(t, registry, "/config/_global/maintenance", false)
+	time.Sleep(watcherStartup)
+
+	writeKey(t, registry, "/config/_global/other", "other")
+
+	// editors replace files by renaming a temporary file
+	temp := keyPath(t, registry, "/config/_global/.maintenance.tmp")
+	require.NoError(t, os.WriteFile(temp, []byte("two"), 0644))
+	require.NoError(t, os.Rename(temp, keyPath(t, registry, "/config/_global/maintenance")))
+
+	event := receive(t, eventChannel)
+	assert.Equal(t, ActionSet, event.Action)
+	assert.Equal(t, "/config/_global/maintenance", event.Key())
+	assert.Equal(t, "two", event.Node.Value)
+	assert.Equal(t, "one", event.PrevValue())
+}
+
+func TestFileRegistry_WatchMissingDirectory(t *testing.T) {
+	registry := newTestFileRegistry(t)
+
+	eventChannel := watch(t, registry, "/services/cas", true)
+	time.Sleep(watcherStartup)
+
+	writeKey(t, registry, "/services/cas/one", "one")
+
+	event := receive(t, eventChannel)
+	assert.True(t, event.IsResync())
+	assert.Equal(t, "/services/cas", event.Key())
+
+	writeKey(t, registry, "/services/cas/one", "two")
+	event = receive(t, eventChannel)
+	assert.Equal(t, ActionSet, event.Action)
+	assert.Equal(t, "two", event.Node.Value)
+}
+
+func TestFileRegistry_GetOutsideOfDirectory(t *testing.T) {
+	registry := newTestFileRegistry(t)
+	writeKey(t, registry, "/config/key", "value")
+	secret := filepath.Join(filepath.Dir(registry.directory), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0644))
+
+	for _, key := range []string{"/../secret", "/config/../../secret", "../" + filepath.Base(registry.directory) + "/../secret"} {
+		_, err := registry.Get(context.Background(), key)
+		require.Error(t, err, key)
+		assert.Contains(t, err.Error(), "outside of the directory")
+	}
+
+	node, err := registry.Get(context.Background(), "/config/../config/key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", node.Value)
+}
+
+func TestFileRegistry_WatchOutsideOfDirectory(t *testing.T) {
+	registry := newTestFileRegistry(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Watch(ctx, "/../", true, make(chan *Event))
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultHubHistory is the number of events which are kept by the WatchHub for subscribers which start late
+const DefaultHubHistory = 1024
+
+// WatchHub multiplexes the watches of all generators onto a single registry. The hub watches a minimal set of
+// prefixes once and fans the events out to the subscribers by key, so that every prefix needs only one connection to
+// the registry. WatchHub implements the Registry interface and can be used by the generators like any other registry.
+type WatchHub struct {
+	registry Registry
+	prefixes []string
+	// keys are the keys which are watched by the generators, only their reads are remembered
+	keys map[string]bool
+
+	mutex   sync.Mutex
+	history *eventLog
+}
+
+// NewWatchHub creates a WatchHub which watches the minimal set of prefixes which covers all the given keys
+func NewWatchHub(registry Registry, keys ...string) *WatchHub {
+	watched := map[string]bool{}
+	for _, key := range keys {
+		if key != "" {
+			watched[normalizeKey(key)] = true
+		}
+	}
+
+	return &WatchHub{
+		registry: registry,
+		prefixes: minimalPrefixes(keys),
+		keys:     watched,
+		history:  newEventLog(DefaultHubHistory),
+	}
+}
+
+// Prefixes returns the prefixes which are watched by the hub
+func (h *WatchHub) Prefixes() []string {
+	return h.prefixes
+}
+
+// Run watches the prefixes of the hub and collects their events for the subscribers. Run returns after the context is
+// cancelled and all watches of the registry have stopped.
+func (h *WatchHub) Run(ctx context.Context) {
+	upstream := make(chan *Event)
+
+	var watchers sync.WaitGroup
+	for _, prefix := range h.prefixes {
+		// the read pins the index of the registry, so that the watch cannot miss a change which the generators
+		// have already read
+		_, err := h.registry.Get(ctx, prefix)
+		if err != nil && !IsKeyNotFound(err) {
+			log.Printf("failed to read prefix %s of watch hub: %v", prefix, err)
+		}
+
+		watchers.Add(1)
+		go func(prefix string) {
+			defer watchers.Done()
+			log.Printf("watch hub starts watching %s", prefix)
+			h.registry.Watch(ctx, prefix, true, upstream)
+		}(prefix)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			watchers.Wait()
+			log.Println("stopped watch hub")
+			return
+		case event := <-upstream:
+			h.mutex.Lock()
+			h.history.append(event)
+			h.mutex.Unlock()
+		}
+	}
+}
+
+// Get reads the key from the registry and remembers the position of the first read of a watched key, so that a
+// subsequent watch of the key receives all changes after the read
+func (h *WatchHub) Get(ctx context.Context, key string) (*Node, error) {
+	h.mutex.Lock()
+	h.markRead(key, h.history.seq)
+	h.mutex.Unlock()
+
+	return h.registry.Get(ctx, key)
+}
+
+// markRead remembers the read of a watched key. Other keys, e.g. the keys below a watched directory, are read by the
+// generators, but not watched on their own, so their reads are not kept.
+func (h *WatchHub) markRead(key string, seq uint64) {
+	if h.keys[normalizeKey(key)] {
+		h.history.markRead(key, seq)
+	}
+}
+
+// Snapshot returns a consistent view of the registry, if the registry supports snapshots. Watches of keys which were
+// read from the snapshot start at the position of the snapshot.
+func (h *WatchHub) Snapshot() Registry {
+	h.mutex.Lock()
+	seq := h.history.seq
+	h.mutex.Unlock()
+
+	// the snapshot is created after the position was taken, so it contains at least the changes until the position
+	return &hubSnapshot{hub: h, snapshot: Snapshot(h.registry), seq: seq}
+}
+
+// Watch subscribes to the events of the key. The events start after the first get of the key or at the current
+// position, if the key was not read before or is not one of the watched keys of the hub. Keys which are not covered by the prefixes of the hub are watched
+// directly. Watch returns only if the context is cancelled.
+func (h *WatchHub) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+	if !h.covers(key) {
+		log.Printf("key %s is not covered by the watch hub, watch it directly", key)
+		h.registry.Watch(ctx, key, recursive, eventChannel)
+		return
+	}
+
+	h.mutex.Lock()
+	seq := h.history.start(key)
+	h.mutex.Unlock()
+
+	h.history.follow(ctx, &h.mutex, seq, key, recursive, eventChannel)
+}
+
+func (h *WatchHub) covers(key string) bool {
+	for _, prefix := range h.prefixes {
+		if key == prefix || strings.HasPrefix(key, directoryPrefix(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// minimalPrefixes returns the sorted keys without the keys which are below another key, empty keys are ignored
+func minimalPrefixes(keys []string) []string {
+	normalized := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key != "" {
+			normalized = append(normalized, normalizeKey(key))
+		}
+	}
+	sort.Strings(normalized)
+
+	hub := &WatchHub{}
+	for _, key := range normalized {
+		// parents are sorted before their children, so every key is compared with all of its possible parents
+		if !hub.covers(key) {
+			hub.prefixes = append(hub.prefixes, key)
+		}
+	}
+	return hub.prefixes
+}
+
+// hubSnapshot reads from a snapshot of the registry of the hub
+type hubSnapshot struct {
+	hub      *WatchHub
+	snapshot Registry
+	seq      uint64
+}
+
+func (s *hubSnapshot) Get(ctx context.Context, key string) (*Node, error) {
+	s.hub.mutex.Lock()
+	s.hub.markRead(key, s.seq)
+	s.hub.mutex.Unlock()
+
+	return s.snapshot.Get(ctx, key)
+}
+
+func (s *hubSnapshot) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	s.hub.Watch(ctx, key, recursive, eventChannel)
+}
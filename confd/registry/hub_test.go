@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startHub(t *testing.T, hub *WatchHub) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hub.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ctx
+}
+
+func assertNoEvent(t *testing.T, eventChannel chan *Event) {
+	select {
+	case event := <-eventChannel:
+		t.Fatalf("received unexpected event %s of %s", event.Action, event.Key())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMinimalPrefixes(t *testing.T) {
+	prefixes := minimalPrefixes([]string{"/services", "config/_global/maintenance", "/config-x", "/config", "/dogu", "/services/", ""})
+	assert.Equal(t, []string{"/config", "/config-x", "/dogu", "/services"}, prefixes)
+}
+
+func TestWatchHub_Watch(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "one"))
+	require.NoError(t, registry.Set("/config/_global/maintenance", "off"))
+
+	hub := NewWatchHub(registry, "/services", "/config/_global/maintenance", "/config/_global")
+	assert.Equal(t, []string{"/config/_global", "/services"}, hub.Prefixes())
+
+	ctx := startHub(t, hub)
+
+	_, err := hub.Get(ctx, "/services")
+	require.NoError(t, err)
+	_, err = hub.Get(ctx, "/config/_global/maintenance")
+	require.NoError(t, err)
+
+	// the change after the read must reach the subscriber, even if it subscribes later
+	require.NoError(t, registry.Set("/services/cas/one", "two"))
+
+	services := watch(t, hub, "/services", true)
+	maintenance := watch(t, hub, "/config/_global/maintenance", false)
+
+	event := receive(t, services)
+	assert.Equal(t, "/services/cas/one", event.Key())
+	assert.Equal(t, "two", event.Node.Value)
+
+	t.Run("should fan out events by key", func(t *testing.T) {
+		require.NoError(t, registry.Set("/config/_global/fqdn", "ces.local"))
+		require.NoError(t, registry.Set("/config/_global/maintenance", "on"))
+		require.NoError(t, registry.Set("/services/nginx/one", "nginx"))
+
+		event := receive(t, maintenance)
+		assert.Equal(t, "/config/_global/maintenance", event.Key())
+		assert.Equal(t, "on", event.Node.Value)
+
+		event = receive(t, services)
+		assert.Equal(t, "/services/nginx/one", event.Key())
+
+		assertNoEvent(t, maintenance)
+		assertNoEvent(t, services)
+	})
+
+	t.Run("should send delete of parent directory", func(t *testing.T) {
+		require.NoError(t, registry.Delete("/config/_global", true))
+
+		event := receive(t, maintenance)
+		assert.Equal(t, ActionDelete, event.Action)
+		assert.Equal(t, "/config/_global", event.Key())
+		assertNoEvent(t, services)
+	})
+}
+
+func TestWatchHub_WatchUncoveredKey(t *testing.T) {
+	registry := NewMemoryRegistry()
+	hub := NewWatchHub(registry, "/services")
+	ctx := startHub(t, hub)
+
+	_, err := hub.Get(ctx, "/")
+	require.NoError(t, err)
+
+	externals := watch(t, hub, "/externals", true)
+	require.NoError(t, registry.Set("/externals/cloudogu", "{}"))
+
+	event := receive(t, externals)
+	assert.Equal(t, "/externals/cloudogu", event.Key())
+}
+
+func TestWatchHub_WatchDroppedEvents(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Mkdir("/services"))
+
+	hub := NewWatchHub(registry, "/services")
+	hub.history = newEventLog(2)
+	ctx := startHub(t, hub)
+
+	_, err := hub.Get(ctx, "/services")
+	require.NoError(t, err)
+
+	require.NoError(t, registry.Set("/services/one", "1"))
+	require.NoError(t, registry.Set("/services/two", "2"))
+	require.NoError(t, registry.Set("/services/three", "3"))
+	require.Eventually(t, func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		return hub.history.seq == 3
+	}, 5*time.Second, time.Millisecond)
+
+	services := watch(t, hub, "/services", true)
+
+	event := receive(t, services)
+	assert.True(t, event.IsResync())
+	assert.Equal(t, "/services", event.Key())
+
+	event = receive(t, services)
+	assert.Equal(t, "/services/two", event.Key())
+	event = receive(t, services)
+	assert.Equal(t, "/services/three", event.Key())
+}
+
+func TestWatchHub_Get_onlyRemembersWatchedKeys(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "one"))
+	require.NoError(t, registry.Set("/config/nginx/buffering/cas", "off"))
+
+	hub := NewWatchHub(registry, "/services", "/config/nginx/buffering")
+	ctx := startHub(t, hub)
+
+	_, err := hub.Get(ctx, "/services")
+	require.NoError(t, err)
+	_, err = hub.Snapshot().Get(ctx, "/config/nginx/buffering/cas")
+	require.NoError(t, err)
+	_, err = hub.Get(ctx, "/services/cas/one")
+	require.NoError(t, err)
+	_, err = hub.Get(ctx, "/config/_global/fqdn")
+	require.Error(t, err)
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	assert.Equal(t, map[string]uint64{"/services": 0}, hub.history.reads)
+}
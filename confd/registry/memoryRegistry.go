@@ -0,0 +1,248 @@
+package registry
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryRegistry implements the Registry interface in memory with the semantics of the etcd v2 api, e.g. for tests of
+// the generators. Every change gets the next index and is kept in the history. Like the etcd registries all watches
+// start after the index of the first read, so that a watch receives all changes after the read, even if they were
+// made before the watch was started.
+type MemoryRegistry struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryEntry
+	index   uint64
+	history *eventLog
+	// readIndex is the index of the first read, all watches start after this index
+	readIndex uint64
+	read      bool
+}
+
+type memoryEntry struct {
+	value         string
+	dir           bool
+	modifiedIndex uint64
+}
+
+// NewMemoryRegistry creates a new empty MemoryRegistry
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		entries: map[string]*memoryEntry{"/": {dir: true}},
+		history: newEventLog(0),
+	}
+}
+
+// Index returns the index of the last change
+func (r *MemoryRegistry) Index() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.index
+}
+
+// Set writes the value of the key and creates the missing parent directories
+func (r *MemoryRegistry) Set(key string, value string) error {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	prev, exists := r.entries[key]
+	if exists && prev.dir {
+		return errors.Errorf("key %s is a directory", key)
+	}
+
+	err := r.createParents(key)
+	if err != nil {
+		return err
+	}
+
+	index := r.nextIndex()
+	r.entries[key] = &memoryEntry{value: value, modifiedIndex: index}
+
+	event := &Event{Action: ActionSet, Node: &Node{Key: key, Value: value, ModifiedIndex: index}, Index: index}
+	if exists {
+		event.PrevNode = prev.node(key)
+	}
+	r.publish(event)
+	return nil
+}
+
+// Mkdir creates the directory and its missing parents
+func (r *MemoryRegistry) Mkdir(key string) error {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.entries[key]; exists {
+		return errors.Errorf("key %s already exists", key)
+	}
+
+	err := r.createParents(key)
+	if err != nil {
+		return err
+	}
+
+	index := r.nextIndex()
+	r.entries[key] = &memoryEntry{dir: true, modifiedIndex: index}
+	r.publish(&Event{Action: ActionCreate, Node: &Node{Key: key, Dir: true, ModifiedIndex: index}, Index: index})
+	return nil
+}
+
+// Delete removes the key. Directories which are not empty can only be removed recursively.
+func (r *MemoryRegistry) Delete(key string, recursive bool) error {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	prev, exists := r.entries[key]
+	if !exists {
+		return &KeyNotFoundError{Key: key}
+	}
+	if key == "/" {
+		return errors.New("the root directory cannot be removed")
+	}
+
+	if prev.dir {
+		children := r.descendants(key)
+		if len(children) > 0 && !recursive {
+			return errors.Errorf("directory %s is not empty", key)
+		}
+		for _, child := range children {
+			delete(r.entries, child)
+		}
+	}
+	delete(r.entries, key)
+
+	index := r.nextIndex()
+	r.publish(&Event{Action: ActionDelete, Node: &Node{Key: key, Dir: prev.dir, ModifiedIndex: index}, PrevNode: prev.node(key), Index: index})
+	return nil
+}
+
+// Get returns the node of the key, directories contain their direct children
+func (r *MemoryRegistry) Get(_ context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, exists := r.entries[key]
+	if !exists {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+	r.markRead()
+
+	node := entry.node(key)
+	if !entry.dir {
+		return node, nil
+	}
+
+	node.Nodes = Nodes{}
+	for _, child := range r.descendants(key) {
+		if path.Dir(child) == key {
+			node.Nodes = append(node.Nodes, r.entries[child].node(child))
+		}
+	}
+	return node, nil
+}
+
+// GetTree returns the node of the key with all of its descendants
+func (r *MemoryRegistry) GetTree(_ context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, exists := r.entries[key]
+	if !exists {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+	r.markRead()
+
+	nodes := map[string]*Node{key: entry.node(key)}
+	for _, descendant := range r.descendants(key) {
+		node := r.entries[descendant].node(descendant)
+		nodes[descendant] = node
+		// the keys are sorted, so the parent was created before its children
+		parent := nodes[path.Dir(descendant)]
+		parent.Nodes = append(parent.Nodes, node)
+	}
+	return nodes[key], nil
+}
+
+// Watch sends the changes of the key to the channel. The watch starts after the index of the first read or at the
+// current index, if the registry was not read before. Watch returns only if the context is cancelled.
+func (r *MemoryRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	afterIndex := r.index
+	if r.read {
+		afterIndex = r.readIndex
+	}
+	r.mutex.Unlock()
+
+	r.history.follow(ctx, &r.mutex, afterIndex, key, recursive, eventChannel)
+}
+
+// createParents creates the missing parent directories of the key with the index of the change
+func (r *MemoryRegistry) createParents(key string) error {
+	parent := path.Dir(key)
+	entry, exists := r.entries[parent]
+	if exists {
+		if !entry.dir {
+			return errors.Errorf("key %s is not a directory", parent)
+		}
+		return nil
+	}
+
+	err := r.createParents(parent)
+	if err != nil {
+		return err
+	}
+	r.entries[parent] = &memoryEntry{dir: true, modifiedIndex: r.index + 1}
+	return nil
+}
+
+// descendants returns the sorted keys below the directory
+func (r *MemoryRegistry) descendants(key string) []string {
+	prefix := directoryPrefix(key)
+	var keys []string
+	for candidate := range r.entries {
+		if candidate != key && strings.HasPrefix(candidate, prefix) {
+			keys = append(keys, candidate)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// markRead remembers the index of the first read
+func (r *MemoryRegistry) markRead() {
+	if !r.read {
+		r.readIndex = r.index
+		r.read = true
+	}
+}
+
+func (r *MemoryRegistry) nextIndex() uint64 {
+	r.index++
+	return r.index
+}
+
+// publish adds the event to the history, every change creates exactly one event, so the sequence numbers of the
+// history are the indices of the changes
+func (r *MemoryRegistry) publish(event *Event) {
+	r.history.append(event)
+}
+
+func (entry *memoryEntry) node(key string) *Node {
+	return &Node{Key: key, Value: entry.value, Dir: entry.dir, ModifiedIndex: entry.modifiedIndex}
+}
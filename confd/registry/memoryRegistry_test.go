@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRegistry_Get(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/nginx/one", "nginx"))
+	require.NoError(t, registry.Set("services/cas/one", "cas"))
+	require.NoError(t, registry.Set("/services/cas/two", "cas"))
+	require.NoError(t, registry.Mkdir("/dogu"))
+
+	t.Run("should return value of key", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "services/cas/one/")
+		require.NoError(t, err)
+		assert.Equal(t, "/services/cas/one", node.Key)
+		assert.Equal(t, "cas", node.Value)
+		assert.Equal(t, uint64(2), node.ModifiedIndex)
+	})
+
+	t.Run("should return direct children of directory", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services")
+		require.NoError(t, err)
+		assert.True(t, node.Dir)
+		require.Len(t, node.Nodes, 2)
+		assert.Equal(t, "/services/cas", node.Nodes[0].Key)
+		assert.True(t, node.Nodes[0].Dir)
+		assert.Empty(t, node.Nodes[0].Nodes)
+		assert.Equal(t, "/services/nginx", node.Nodes[1].Key)
+	})
+
+	t.Run("should return empty directory", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/dogu")
+		require.NoError(t, err)
+		assert.True(t, node.Dir)
+		assert.Empty(t, node.Nodes)
+	})
+
+	t.Run("should return key not found error", func(t *testing.T) {
+		_, err := registry.Get(context.Background(), "/config")
+		assert.True(t, IsKeyNotFound(err))
+	})
+}
+
+func TestMemoryRegistry_Modifications(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "cas"))
+
+	assert.Error(t, registry.Set("/services/cas", "value"), "directories cannot be overwritten")
+	assert.Error(t, registry.Set("/services/cas/one/two", "value"), "values cannot contain keys")
+	assert.Error(t, registry.Mkdir("/services/cas"), "directory exists")
+	assert.Error(t, registry.Delete("/services", false), "directory is not empty")
+	assert.True(t, IsKeyNotFound(registry.Delete("/dogu", false)))
+	assert.Equal(t, uint64(1), registry.Index(), "failed modifications must not change the index")
+
+	require.NoError(t, registry.Delete("/services", true))
+	_, err := registry.Get(context.Background(), "/services/cas/one")
+	assert.True(t, IsKeyNotFound(err))
+	assert.Equal(t, uint64(2), registry.Index())
+}
+
+func TestMemoryRegistry_Watch(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/services/cas/one", "one"))
+
+	_, err := registry.Get(context.Background(), "/services")
+	require.NoError(t, err)
+
+	// changes after the get must not get lost, even if the watch is not started yet
+	require.NoError(t, registry.Set("/services/cas/one", "updated"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventChannel := make(chan *Event)
+	go registry.Watch(ctx, "/services", true, eventChannel)
+
+	event := receive(t, eventChannel)
+	assert.Equal(t, ActionSet, event.Action)
+	assert.Equal(t, "updated", event.Node.Value)
+	assert.Equal(t, "one", event.PrevValue())
+	assert.Equal(t, uint64(2), event.Index)
+
+	require.NoError(t, registry.Set("/servicesX/other", "other"))
+	require.NoError(t, registry.Mkdir("/services/nginx"))
+	event = receive(t, eventChannel)
+	assert.Equal(t, ActionCreate, event.Action)
+	assert.Equal(t, "/services/nginx", event.Key())
+	assert.True(t, event.Node.Dir)
+	assert.Equal(t, uint64(4), event.Index)
+
+	require.NoError(t, registry.Delete("/services/cas/one", false))
+	event = receive(t, eventChannel)
+	assert.Equal(t, ActionDelete, event.Action)
+	assert.Equal(t, "updated", event.PrevValue())
+}
+
+func TestMemoryRegistry_WatchKey(t *testing.T) {
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.Set("/config/_global/maintenance", "one"))
+
+	_, err := registry.Get(context.Background(), "/config/_global/maintenance")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventChannel := make(chan *Event)
+	go registry.Watch(ctx, "/config/_global/maintenance", false, eventChannel)
+
+	t.Run("should only send changes of the key", func(t *testing.T) {
+		require.NoError(t, registry.Set("/config/_global/other", "other"))
+		require.NoError(t, registry.Set("/config/_global/maintenance", "two"))
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, "/config/_global/maintenance", event.Key())
+		assert.Equal(t, "two", event.Node.Value)
+	})
+
+	t.Run("should send delete of parent directory", func(t *testing.T) {
+		require.NoError(t, registry.Delete("/config", true))
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionDelete, event.Action)
+		assert.Equal(t, "/config", event.Key())
+	})
+}
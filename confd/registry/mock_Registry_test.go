@@ -3,8 +3,9 @@
 package registry
 
 import (
+	context "context"
+
 	mock "github.com/stretchr/testify/mock"
-	client "go.etcd.io/etcd/client/v2"
 )
 
 // MockRegistry is an autogenerated mock type for the Registry type
@@ -20,29 +21,29 @@ func (_m *MockRegistry) EXPECT() *MockRegistry_Expecter {
 	return &MockRegistry_Expecter{mock: &_m.Mock}
 }
 
-// Get provides a mock function with given fields: key
-func (_m *MockRegistry) Get(key string) (*client.Response, error) {
-	ret := _m.Called(key)
+// Get provides a mock function with given fields: ctx, key
+func (_m *MockRegistry) Get(ctx context.Context, key string) (*Node, error) {
+	ret := _m.Called(ctx, key)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Get")
 	}
 
-	var r0 *client.Response
+	var r0 *Node
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (*client.Response, error)); ok {
-		return rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*Node, error)); ok {
+		return rf(ctx, key)
 	}
-	if rf, ok := ret.Get(0).(func(string) *client.Response); ok {
-		r0 = rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *Node); ok {
+		r0 = rf(ctx, key)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*client.Response)
+			r0 = ret.Get(0).(*Node)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(key)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -56,31 +57,32 @@ type MockRegistry_Get_Call struct {
 }
 
 // Get is a helper method to define mock.On call
+//   - ctx context.Context
 //   - key string
-func (_e *MockRegistry_Expecter) Get(key interface{}) *MockRegistry_Get_Call {
-	return &MockRegistry_Get_Call{Call: _e.mock.On("Get", key)}
+func (_e *MockRegistry_Expecter) Get(ctx interface{}, key interface{}) *MockRegistry_Get_Call {
+	return &MockRegistry_Get_Call{Call: _e.mock.On("Get", ctx, key)}
 }
 
-func (_c *MockRegistry_Get_Call) Run(run func(key string)) *MockRegistry_Get_Call {
+func (_c *MockRegistry_Get_Call) Run(run func(ctx context.Context, key string)) *MockRegistry_Get_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string))
+		run(args[0].(context.Context), args[1].(string))
 	})
 	return _c
 }
 
-func (_c *MockRegistry_Get_Call) Return(_a0 *client.Response, _a1 error) *MockRegistry_Get_Call {
+func (_c *MockRegistry_Get_Call) Return(_a0 *Node, _a1 error) *MockRegistry_Get_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockRegistry_Get_Call) RunAndReturn(run func(string) (*client.Response, error)) *MockRegistry_Get_Call {
+func (_c *MockRegistry_Get_Call) RunAndReturn(run func(context.Context, string) (*Node, error)) *MockRegistry_Get_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Watch provides a mock function with given fields: key, recursive, eventChannel
-func (_m *MockRegistry) Watch(key string, recursive bool, eventChannel chan *client.Response) {
-	_m.Called(key, recursive, eventChannel)
+// Watch provides a mock function with given fields: ctx, key, recursive, eventChannel
+func (_m *MockRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	_m.Called(ctx, key, recursive, eventChannel)
 }
 
 // MockRegistry_Watch_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Watch'
@@ -89,16 +91,17 @@ type MockRegistry_Watch_Call struct {
 }
 
 // Watch is a helper method to define mock.On call
+//   - ctx context.Context
 //   - key string
 //   - recursive bool
-//   - eventChannel chan *client.Response
-func (_e *MockRegistry_Expecter) Watch(key interface{}, recursive interface{}, eventChannel interface{}) *MockRegistry_Watch_Call {
-	return &MockRegistry_Watch_Call{Call: _e.mock.On("Watch", key, recursive, eventChannel)}
+//   - eventChannel chan *Event
+func (_e *MockRegistry_Expecter) Watch(ctx interface{}, key interface{}, recursive interface{}, eventChannel interface{}) *MockRegistry_Watch_Call {
+	return &MockRegistry_Watch_Call{Call: _e.mock.On("Watch", ctx, key, recursive, eventChannel)}
 }
 
-func (_c *MockRegistry_Watch_Call) Run(run func(key string, recursive bool, eventChannel chan *client.Response)) *MockRegistry_Watch_Call {
+func (_c *MockRegistry_Watch_Call) Run(run func(ctx context.Context, key string, recursive bool, eventChannel chan *Event)) *MockRegistry_Watch_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(bool), args[2].(chan *client.Response))
+		run(args[0].(context.Context), args[1].(string), args[2].(bool), args[3].(chan *Event))
 	})
 	return _c
 }
@@ -108,7 +111,7 @@ func (_c *MockRegistry_Watch_Call) Return() *MockRegistry_Watch_Call {
 	return _c
 }
 
-func (_c *MockRegistry_Watch_Call) RunAndReturn(run func(string, bool, chan *client.Response)) *MockRegistry_Watch_Call {
+func (_c *MockRegistry_Watch_Call) RunAndReturn(run func(context.Context, string, bool, chan *Event)) *MockRegistry_Watch_Call {
 	_c.Call.Return(run)
 	return _c
 }
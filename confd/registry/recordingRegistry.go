@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// RecordGet is the type of a record of a read
+	RecordGet = "get"
+	// RecordWatch is the type of a record of a started watch
+	RecordWatch = "watch"
+	// RecordEvent is the type of a record of an event, which was sent to a watch
+	RecordEvent = "event"
+)
+
+// Record is a line of a recording. Watch is the number of the watch among all watches of the same key, so that the
+// events of concurrent watches of the same key can be told apart.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Key       string    `json:"key"`
+	Recursive bool      `json:"recursive,omitempty"`
+	Watch     int       `json:"watch,omitempty"`
+	Node      *Node     `json:"node,omitempty"`
+	Event     *Event    `json:"event,omitempty"`
+	NotFound  bool      `json:"notFound,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// watchID identifies the watches of a key
+type watchID struct {
+	key       string
+	recursive bool
+}
+
+// RecordingRegistry records every read and every event of the registry as json lines, e.g. to reproduce the
+// generated files of a production system offline with a ReplayRegistry.
+type RecordingRegistry struct {
+	registry Registry
+	now      func() time.Time
+
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	watches map[watchID]int
+}
+
+// NewRecordingRegistry creates a RecordingRegistry which writes the records to the writer
+func NewRecordingRegistry(registry Registry, writer io.Writer) *RecordingRegistry {
+	return &RecordingRegistry{
+		registry: registry,
+		now:      time.Now,
+		encoder:  json.NewEncoder(writer),
+		watches:  map[watchID]int{},
+	}
+}
+
+// Get reads the key from the registry and records the response
+func (r *RecordingRegistry) Get(ctx context.Context, key string) (*Node, error) {
+	node, err := r.registry.Get(ctx, key)
+
+	record := &Record{Type: RecordGet, Key: normalizeKey(key), Node: node}
+	if IsKeyNotFound(err) {
+		record.NotFound = true
+	} else if err != nil {
+		record.Error = err.Error()
+	}
+
+	r.mutex.Lock()
+	r.write(record)
+	r.mutex.Unlock()
+
+	return node, err
+}
+
+// Watch watches the key and records every event before it is sent to the channel. Watch returns only if the context
+// is cancelled.
+func (r *RecordingRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+	id := watchID{key: key, recursive: recursive}
+
+	r.mutex.Lock()
+	watch := r.watches[id]
+	r.watches[id]++
+	r.write(&Record{Type: RecordWatch, Key: key, Recursive: recursive, Watch: watch})
+	r.mutex.Unlock()
+
+	events := make(chan *Event)
+	var watcher sync.WaitGroup
+	watcher.Add(1)
+	go func() {
+		defer watcher.Done()
+		r.registry.Watch(ctx, key, recursive, events)
+	}()
+	defer watcher.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			r.mutex.Lock()
+			r.write(&Record{Type: RecordEvent, Key: key, Recursive: recursive, Watch: watch, Event: event})
+			r.mutex.Unlock()
+
+			if !send(ctx, eventChannel, event) {
+				return
+			}
+		}
+	}
+}
+
+// write appends the record to the recording, the caller has to hold the mutex
+func (r *RecordingRegistry) write(record *Record) {
+	record.Time = r.now()
+	err := r.encoder.Encode(record)
+	if err != nil {
+		log.Printf("failed to record %s of %s: %v", record.Type, record.Key, err)
+	}
+}
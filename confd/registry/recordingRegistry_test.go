@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readRecords(t *testing.T, recording *bytes.Buffer) []Record {
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(recording.String()), "\n") {
+		record := Record{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRecordingRegistry(t *testing.T) {
+	memory := NewMemoryRegistry()
+	require.NoError(t, memory.Set("/services/cas/one", "cas"))
+
+	recording := &bytes.Buffer{}
+	registry := NewRecordingRegistry(memory, recording)
+	registry.now = func() time.Time {
+		return time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := registry.Get(ctx, "services/cas")
+	require.NoError(t, err)
+	assert.Len(t, node.Nodes, 1)
+
+	_, err = registry.Get(ctx, "/dogu")
+	require.Error(t, err)
+	assert.True(t, IsKeyNotFound(err))
+
+	eventChannel := make(chan *Event)
+	done := make(chan struct{})
+	go func() {
+		registry.Watch(ctx, "/services", true, eventChannel)
+		close(done)
+	}()
+
+	require.NoError(t, memory.Set("/services/cas/one", "changed"))
+	event := receive(t, eventChannel)
+	assert.Equal(t, "changed", event.Node.Value)
+
+	cancel()
+	<-done
+
+	records := readRecords(t, recording)
+	require.Len(t, records, 4)
+
+	assert.Equal(t, RecordGet, records[0].Type)
+	assert.Equal(t, "/services/cas", records[0].Key)
+	assert.Equal(t, "/services/cas/one", records[0].Node.Nodes[0].Key)
+	assert.Equal(t, time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC), records[0].Time)
+
+	assert.Equal(t, RecordGet, records[1].Type)
+	assert.True(t, records[1].NotFound)
+	assert.Nil(t, records[1].Node)
+
+	assert.Equal(t, RecordWatch, records[2].Type)
+	assert.Equal(t, "/services", records[2].Key)
+	assert.True(t, records[2].Recursive)
+
+	assert.Equal(t, RecordEvent, records[3].Type)
+	assert.Equal(t, ActionSet, records[3].Event.Action)
+	assert.Equal(t, "changed", records[3].Event.Node.Value)
+	assert.Equal(t, "cas", records[3].Event.PrevValue())
+}
+
+func TestRecordingRegistry_ConcurrentWatches(t *testing.T) {
+	memory := NewMemoryRegistry()
+	_, err := memory.Get(context.Background(), "/")
+	require.NoError(t, err)
+
+	recording := &bytes.Buffer{}
+	registry := NewRecordingRegistry(memory, recording)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan *Event, 1)
+	second := make(chan *Event, 1)
+	done := make(chan struct{}, 2)
+	for _, eventChannel := range []chan *Event{first, second} {
+		go func(eventChannel chan *Event) {
+			registry.Watch(ctx, "/services", true, eventChannel)
+			done <- struct{}{}
+		}(eventChannel)
+	}
+
+	require.NoError(t, memory.Set("/services/cas/one", "cas"))
+	receive(t, first)
+	receive(t, second)
+	cancel()
+	<-done
+	<-done
+
+	watches := map[int]int{}
+	for _, record := range readRecords(t, recording) {
+		if record.Type == RecordEvent {
+			watches[record.Watch]++
+		}
+	}
+	assert.Equal(t, map[int]int{0: 1, 1: 1}, watches)
+}
@@ -1,20 +1,269 @@
 package registry
 
-import "go.etcd.io/etcd/client/v2"
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
 
-// Config represents the configuration of a Registry
+	"github.com/pkg/errors"
+)
+
+const (
+	// BackendEtcd uses the etcd v2 keys api
+	BackendEtcd = "etcd"
+	// BackendEtcdV3 uses the etcd v3 kv and watch api
+	BackendEtcdV3 = "etcd3"
+	// BackendFile reads the keys from the files of a local directory
+	BackendFile = "file"
+	// BackendConsul uses the consul kv http api
+	BackendConsul = "consul"
+)
+
+const (
+	// ActionGet is the action of a node which was read from the registry
+	ActionGet = "get"
+	// ActionCreate is the action of an event for a new key
+	ActionCreate = "create"
+	// ActionSet is the action of an event for a key which was written
+	ActionSet = "set"
+	// ActionUpdate is the action of an event for an existing key which was updated
+	ActionUpdate = "update"
+	// ActionDelete is the action of an event for a removed key
+	ActionDelete = "delete"
+	// ActionExpire is the action of an event for a key whose ttl has expired
+	ActionExpire = "expire"
+	// ActionCompareAndSwap is the action of an event for a key which was updated conditionally
+	ActionCompareAndSwap = "compareAndSwap"
+	// ActionCompareAndDelete is the action of an event for a key which was removed conditionally
+	ActionCompareAndDelete = "compareAndDelete"
+	// ActionResync is the action of an event which is not caused by a single key, it signals that changes below the
+	// watched key may have been lost and that consumers have to read the whole tree again
+	ActionResync = "resync"
+)
+
+const (
+	// DefaultDialTimeout is used if no dial timeout is configured
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultAutoSyncInterval is used if no auto sync interval is configured, but more than one endpoint
+	DefaultAutoSyncInterval = 30 * time.Second
+)
+
+// Config represents the configuration of a Registry. Username and password are used for the basic auth of the etcd
+// v2 api and for the authentication of the etcd v3 api. The header timeout limits the time to wait for the response
+// of a read, watches are not affected. A header timeout of zero means no timeout. The clients replace the configured
+// endpoints with the client urls of the cluster members every auto sync interval. The synchronization is only enabled
+// by default for more than one endpoint, because the advertised client urls are often not reachable from containers;
+// a negative interval disables it. The directory is only used by the file backend, the token is the acl token of the consul backend.
 type Config struct {
-	Endpoints []string
+	Backend          string
+	Endpoints        []string
+	Retry            RetryPolicy
+	TLS              TLSConfig
+	Username         string
+	Password         string
+	DialTimeout      time.Duration
+	HeaderTimeout    time.Duration
+	AutoSyncInterval time.Duration
+	Directory        string
+	Token            string
+}
+
+func (config Config) dialTimeout() time.Duration {
+	if config.DialTimeout <= 0 {
+		return DefaultDialTimeout
+	}
+	return config.DialTimeout
+}
+
+func (config Config) autoSyncInterval() time.Duration {
+	if config.AutoSyncInterval < 0 {
+		return 0
+	}
+	if config.AutoSyncInterval == 0 {
+		if len(config.Endpoints) > 1 {
+			return DefaultAutoSyncInterval
+		}
+		return 0
+	}
+	return config.AutoSyncInterval
+}
+
+// Node is a key or a directory of the registry
+type Node struct {
+	Key           string `json:"key"`
+	Value         string `json:"value,omitempty"`
+	Dir           bool   `json:"dir,omitempty"`
+	Nodes         Nodes  `json:"nodes,omitempty"`
+	ModifiedIndex uint64 `json:"modifiedIndex,omitempty"`
+}
+
+// Nodes is a collection of nodes
+type Nodes []*Node
+
+// Name returns the last segment of the key
+func (node *Node) Name() string {
+	return path.Base(node.Key)
+}
+
+// String returns a string representation of the node
+func (node *Node) String() string {
+	if node.Dir {
+		return fmt.Sprintf("{key=%s, dir=true, nodes=%d}", node.Key, len(node.Nodes))
+	}
+	return fmt.Sprintf("{key=%s, value=%s}", node.Key, node.Value)
+}
+
+// Walk calls the function for the node and all of its descendants, parents are visited before their children. The
+// traversal stops at the first error, which is returned.
+func (node *Node) Walk(fn func(node *Node) error) error {
+	if node == nil {
+		return nil
+	}
+
+	err := fn(node)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range node.Nodes {
+		err = child.Walk(fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns the node with the given key from the tree below the node or nil, if the tree does not contain the key
+func (node *Node) Find(key string) *Node {
+	key = normalizeKey(key)
+	var found *Node
+	_ = node.Walk(func(candidate *Node) error {
+		if normalizeKey(candidate.Key) == key {
+			found = candidate
+			return errStopWalk
+		}
+		return nil
+	})
+	return found
+}
+
+var errStopWalk = errors.New("stop walk")
+
+// copyNode returns a deep copy of the node with all of its descendants
+func copyNode(node *Node) *Node {
+	if node == nil {
+		return nil
+	}
+	copied := *node
+	if node.Nodes != nil {
+		copied.Nodes = make(Nodes, len(node.Nodes))
+		for i, child := range node.Nodes {
+			copied.Nodes[i] = copyNode(child)
+		}
+	}
+	return &copied
+}
+
+// copyEvent returns a deep copy of the event and its nodes
+func copyEvent(event *Event) *Event {
+	if event == nil {
+		return nil
+	}
+	copied := *event
+	copied.Node = copyNode(event.Node)
+	copied.PrevNode = copyNode(event.PrevNode)
+	return &copied
+}
+
+// send delivers the event to the channel and returns false, if the context was cancelled before the event could be
+// delivered
+func send(ctx context.Context, eventChannel chan *Event, event *Event) bool {
+	select {
+	case eventChannel <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep waits for the duration and returns early, if the context is cancelled
+func sleep(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
 // Event represents a watchable event
 type Event struct {
-	Action string
-	Value  string
+	Action   string `json:"action"`
+	Node     *Node  `json:"node,omitempty"`
+	PrevNode *Node  `json:"prevNode,omitempty"`
+	Index    uint64 `json:"index,omitempty"`
+}
+
+// Key returns the key of the changed node
+func (event *Event) Key() string {
+	if event.Node == nil {
+		return ""
+	}
+	return event.Node.Key
+}
+
+// IsResync returns true if the consumer has to read the whole tree again instead of applying the event
+func (event *Event) IsResync() bool {
+	return event.Action == ActionResync
+}
+
+// PrevValue returns the value of the node before the change or an empty string, if the node did not exist
+func (event *Event) PrevValue() string {
+	if event.PrevNode == nil {
+		return ""
+	}
+	return event.PrevNode.Value
+}
+
+// KeyNotFoundError is returned by the registries if a key does not exist
+type KeyNotFoundError struct {
+	Key string
+}
+
+func (err *KeyNotFoundError) Error() string {
+	return "key not found: " + err.Key
+}
+
+// IsKeyNotFound returns true if the error or one of its causes is a KeyNotFoundError
+func IsKeyNotFound(err error) bool {
+	var keyNotFound *KeyNotFoundError
+	return errors.As(err, &keyNotFound)
 }
 
 // Registry manages a config registry (e.g. etcd)
 type Registry interface {
-	Get(key string) (*client.Response, error)
-	Watch(key string, recursive bool, eventChannel chan *client.Response)
+	// Get returns the node of the key, directories contain their direct children
+	Get(ctx context.Context, key string) (*Node, error)
+	// Watch sends changes of the key to the channel. Watch blocks until the context is cancelled or the watch fails,
+	// callers should re-read the registry before they watch again.
+	Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event)
+}
+
+// New creates the Registry for the backend of the configuration. The etcd v2 backend is used if no backend is
+// configured.
+func New(ctx context.Context, config Config) (Registry, error) {
+	switch config.Backend {
+	case "", BackendEtcd:
+		return NewEtcdRegistry(ctx, config)
+	case BackendEtcdV3:
+		return NewEtcdV3Registry(ctx, config)
+	case BackendFile:
+		return NewFileRegistry(config)
+	case BackendConsul:
+		return NewConsulRegistry(config)
+	}
+	return nil, errors.Errorf("unknown registry backend %s", config.Backend)
 }
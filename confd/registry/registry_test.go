@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// watch starts a watch of the registry, which is stopped at the end of the test
+func watch(t *testing.T, registry Registry, key string, recursive bool) chan *Event {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	eventChannel := make(chan *Event, 16)
+	go registry.Watch(ctx, key, recursive, eventChannel)
+	return eventChannel
+}
+
+func createTestTree() *Node {
+	return &Node{Key: "/services", Dir: true, Nodes: Nodes{
+		{Key: "/services/cas", Dir: true, Nodes: Nodes{
+			{Key: "/services/cas/one", Value: "one"},
+		}},
+		{Key: "/services/nginx", Dir: true, Nodes: Nodes{
+			{Key: "/services/nginx/one", Value: "two"},
+		}},
+	}}
+}
+
+func TestNode_Walk(t *testing.T) {
+	t.Run("should visit parents before children", func(t *testing.T) {
+		var keys []string
+		err := createTestTree().Walk(func(node *Node) error {
+			keys = append(keys, node.Key)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/services", "/services/cas", "/services/cas/one", "/services/nginx", "/services/nginx/one"}, keys)
+	})
+
+	t.Run("should stop on first error", func(t *testing.T) {
+		var keys []string
+		err := createTestTree().Walk(func(node *Node) error {
+			keys = append(keys, node.Key)
+			if node.Key == "/services/cas" {
+				return assert.AnError
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, []string{"/services", "/services/cas"}, keys)
+	})
+}
+
+func TestNode_Find(t *testing.T) {
+	tree := createTestTree()
+
+	assert.Equal(t, "two", tree.Find("/services/nginx/one").Value)
+	assert.Equal(t, "one", tree.Find("services/cas/one/").Value)
+	assert.Nil(t, tree.Find("/services/ldap"))
+}
+
+func TestNode_Name(t *testing.T) {
+	assert.Equal(t, "one", (&Node{Key: "/services/cas/one"}).Name())
+}
+
+func TestEvent_PrevValue(t *testing.T) {
+	assert.Equal(t, "", (&Event{Action: ActionCreate, Node: &Node{Key: "/a", Value: "b"}}).PrevValue())
+	assert.Equal(t, "a", (&Event{Action: ActionSet, Node: &Node{Key: "/a", Value: "b"}, PrevNode: &Node{Key: "/a", Value: "a"}}).PrevValue())
+}
+
+func TestIsKeyNotFound(t *testing.T) {
+	assert.True(t, IsKeyNotFound(&KeyNotFoundError{Key: "/a"}))
+	assert.True(t, IsKeyNotFound(errors.Wrap(&KeyNotFoundError{Key: "/a"}, "failed")))
+	assert.False(t, IsKeyNotFound(assert.AnError))
+	assert.False(t, IsKeyNotFound(nil))
+}
@@ -0,0 +1,275 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultReplayTimeout is the time a ReplayRegistry waits for a recorded read or watch, before it continues without it
+const DefaultReplayTimeout = time.Second
+
+// ReplayRegistry answers reads and watches with a recording of a RecordingRegistry. The events are sent in the order
+// of the recording and an event is only sent after all reads which were recorded before it were replayed, so that the
+// generators see the same sequence of states as during the recording. Reads and watches which were recorded but are
+// not replayed, e.g. because the generators behave differently, are skipped after the timeout. The timestamps of the
+// recording are not replayed.
+type ReplayRegistry struct {
+	timeout time.Duration
+
+	mutex        sync.Mutex
+	records      []*replayRecord
+	watches      map[watchID]int
+	lastProgress time.Time
+	// changed is closed and replaced on every progress of the replay
+	changed chan struct{}
+}
+
+type replayRecord struct {
+	Record
+	done bool
+}
+
+// NewReplayRegistry reads the recording of a RecordingRegistry
+func NewReplayRegistry(reader io.Reader) (*ReplayRegistry, error) {
+	var records []*replayRecord
+
+	scanner := bufio.NewScanner(reader)
+	// a record contains whole trees
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := &replayRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record.Record)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse line %d of recording", line)
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read recording")
+	}
+
+	return &ReplayRegistry{
+		timeout:      DefaultReplayTimeout,
+		records:      records,
+		watches:      map[watchID]int{},
+		lastProgress: time.Now(),
+		changed:      make(chan struct{}),
+	}, nil
+}
+
+// Get returns the next recorded response of the key before the next event. If all of these responses were already
+// replayed, the last one is repeated. If the key was not read before the next event, the first later response is
+// returned.
+func (r *ReplayRegistry) Get(_ context.Context, key string) (*Node, error) {
+	key = normalizeKey(key)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	next := r.nextEvent()
+	var last *replayRecord
+	for _, record := range r.records[:next] {
+		if record.Type != RecordGet || record.Key != key {
+			continue
+		}
+		if !record.done {
+			record.done = true
+			r.progress()
+			return record.response()
+		}
+		last = record
+	}
+
+	if last != nil {
+		return last.response()
+	}
+
+	for _, record := range r.records[next:] {
+		if record.Type == RecordGet && record.Key == key {
+			return record.response()
+		}
+	}
+	return nil, errors.Errorf("read of key %s was not recorded", key)
+}
+
+// Watch sends the recorded events of the key to the channel. Watch returns only if the context is cancelled.
+func (r *ReplayRegistry) Watch(ctx context.Context, key string, recursive bool, eventChannel chan *Event) {
+	key = normalizeKey(key)
+	id := watchID{key: key, recursive: recursive}
+
+	r.mutex.Lock()
+	watch := r.watches[id]
+	r.watches[id]++
+	for _, record := range r.records {
+		if !record.done && record.Type == RecordWatch && record.matches(id, watch) {
+			record.done = true
+			r.progress()
+			break
+		}
+	}
+	r.mutex.Unlock()
+
+	for {
+		r.mutex.Lock()
+		event, wait := r.dispatch(id, watch)
+		changed := r.changed
+		r.mutex.Unlock()
+
+		if event != nil {
+			if !send(ctx, eventChannel, event) {
+				return
+			}
+			continue
+		}
+
+		if !r.await(ctx, changed, wait) {
+			return
+		}
+	}
+}
+
+// await waits for the next progress of the replay or until the duration has passed, zero waits only for the progress.
+// It returns false if the context was cancelled.
+func (r *ReplayRegistry) await(ctx context.Context, changed chan struct{}, wait time.Duration) bool {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-changed:
+	case <-timeout:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// Wait blocks until all recorded events were sent and the generators have been idle for the timeout, or until the
+// context is cancelled
+func (r *ReplayRegistry) Wait(ctx context.Context) {
+	for {
+		r.mutex.Lock()
+		finished := r.nextEvent() == len(r.records)
+		wait := r.timeout - time.Since(r.lastProgress)
+		changed := r.changed
+		r.mutex.Unlock()
+
+		if finished && wait <= 0 {
+			return
+		}
+		if wait <= 0 {
+			wait = r.timeout
+		}
+
+		if !r.await(ctx, changed, wait) {
+			return
+		}
+	}
+}
+
+// dispatch returns the next event, if it belongs to the watch and all reads and watches before the event were
+// replayed. Otherwise it returns the duration after which the records which block the replay are skipped, zero means
+// that there is nothing to wait for. The caller has to hold the mutex.
+func (r *ReplayRegistry) dispatch(id watchID, watch int) (*Event, time.Duration) {
+	for {
+		next := r.nextEvent()
+		if next == len(r.records) {
+			return nil, 0
+		}
+
+		idle := time.Since(r.lastProgress)
+		if idle < r.timeout && r.pendingBefore(next) {
+			return nil, r.timeout - idle
+		}
+		r.skipBefore(next)
+
+		record := r.records[next]
+		if record.matches(id, watch) {
+			record.done = true
+			r.progress()
+			return copyEvent(record.Event), 0
+		}
+
+		// the event is sent by its own watch
+		if r.watches[watchID{key: record.Key, recursive: record.Recursive}] > record.Watch {
+			return nil, 0
+		}
+		if idle < r.timeout {
+			return nil, r.timeout - idle
+		}
+
+		log.Printf("recorded watch of %s was not replayed, skip its event", record.Key)
+		record.done = true
+		r.progress()
+	}
+}
+
+// nextEvent returns the position of the next event which was not sent or the length of the records
+func (r *ReplayRegistry) nextEvent() int {
+	for i, record := range r.records {
+		if record.Type == RecordEvent && !record.done {
+			return i
+		}
+	}
+	return len(r.records)
+}
+
+func (r *ReplayRegistry) pendingBefore(position int) bool {
+	for _, record := range r.records[:position] {
+		if !record.done {
+			return true
+		}
+	}
+	return false
+}
+
+// skipBefore marks the records before the position as replayed
+func (r *ReplayRegistry) skipBefore(position int) {
+	skipped := false
+	for _, record := range r.records[:position] {
+		if !record.done {
+			log.Printf("recorded %s of %s was not replayed, skip it", record.Type, record.Key)
+			record.done = true
+			skipped = true
+		}
+	}
+	if skipped {
+		r.progress()
+	}
+}
+
+func (r *ReplayRegistry) progress() {
+	r.lastProgress = time.Now()
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (record *replayRecord) matches(id watchID, watch int) bool {
+	return record.Key == id.key && record.Recursive == id.recursive && record.Watch == watch
+}
+
+// response returns a copy of the recorded response of a read
+func (record *replayRecord) response() (*Node, error) {
+	switch {
+	case record.NotFound:
+		return nil, &KeyNotFoundError{Key: record.Key}
+	case record.Error != "":
+		return nil, errors.New(record.Error)
+	}
+	return copyNode(record.Node), nil
+}
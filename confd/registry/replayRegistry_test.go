@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const replayRecording = `{"time":"2026-10-17T12:00:00Z","type":"watch","key":"/services","recursive":true}
+{"time":"2026-10-17T12:00:01Z","type":"get","key":"/services/cas/one","node":{"key":"/services/cas/one","value":"one"}}
+{"time":"2026-10-17T12:00:02Z","type":"event","key":"/services","recursive":true,"event":{"action":"set","node":{"key":"/services/cas/one","value":"two"}}}
+{"time":"2026-10-17T12:00:03Z","type":"get","key":"/services/cas/one","node":{"key":"/services/cas/one","value":"two"}}
+{"time":"2026-10-17T12:00:04Z","type":"get","key":"/dogu","notFound":true}
+{"time":"2026-10-17T12:00:05Z","type":"event","key":"/services","recursive":true,"event":{"action":"delete","node":{"key":"/services/cas/one"}}}
+`
+
+func newTestReplayRegistry(t *testing.T, recording string, timeout time.Duration) *ReplayRegistry {
+	registry, err := NewReplayRegistry(strings.NewReader(recording))
+	require.NoError(t, err)
+	registry.timeout = timeout
+	return registry
+}
+
+// consumeServices reads the value of a key like a generator, once at the start and again after every event
+func consumeServices(t *testing.T, registry Registry, changes int) []string {
+	var values []string
+	read := func() {
+		node, err := registry.Get(context.Background(), "/services/cas/one")
+		require.NoError(t, err)
+		values = append(values, node.Value)
+	}
+
+	read()
+	eventChannel := watch(t, registry, "/services", true)
+	for i := 0; i < changes; i++ {
+		receive(t, eventChannel)
+		read()
+	}
+	return values
+}
+
+func TestNewReplayRegistry(t *testing.T) {
+	_, err := NewReplayRegistry(strings.NewReader("{\"type\": \"get\"}\nno json\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
+
+func TestReplayRegistry(t *testing.T) {
+	registry := newTestReplayRegistry(t, replayRecording, time.Second)
+
+	node, err := registry.Get(context.Background(), "services/cas/one")
+	require.NoError(t, err)
+	assert.Equal(t, "one", node.Value)
+
+	t.Run("should repeat the last read before the next event", func(t *testing.T) {
+		node, err := registry.Get(context.Background(), "/services/cas/one")
+		require.NoError(t, err)
+		assert.Equal(t, "one", node.Value)
+	})
+
+	eventChannel := watch(t, registry, "/services", true)
+
+	t.Run("should send events in the order of the recording", func(t *testing.T) {
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionSet, event.Action)
+		assert.Equal(t, "two", event.Node.Value)
+
+		node, err := registry.Get(context.Background(), "/services/cas/one")
+		require.NoError(t, err)
+		assert.Equal(t, "two", node.Value)
+	})
+
+	t.Run("should wait for the recorded reads before the next event", func(t *testing.T) {
+		assertNoEvent(t, eventChannel)
+
+		_, err := registry.Get(context.Background(), "/dogu")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFound(err))
+
+		event := receive(t, eventChannel)
+		assert.Equal(t, ActionDelete, event.Action)
+	})
+
+	t.Run("should return error for keys which were not recorded", func(t *testing.T) {
+		_, err := registry.Get(context.Background(), "/config")
+		require.Error(t, err)
+		assert.False(t, IsKeyNotFound(err))
+	})
+}
+
+func TestReplayRegistry_SkipMissingReads(t *testing.T) {
+	registry := newTestReplayRegistry(t, replayRecording, 50*time.Millisecond)
+
+	eventChannel := watch(t, registry, "/services", true)
+	assert.Equal(t, "two", receive(t, eventChannel).Node.Value)
+	assert.Equal(t, ActionDelete, receive(t, eventChannel).Action)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	registry.Wait(ctx)
+	assert.NoError(t, ctx.Err(), "replay should be finished")
+}
+
+func TestReplayRegistry_RecordedSession(t *testing.T) {
+	memory := NewMemoryRegistry()
+	require.NoError(t, memory.Set("/services/cas/one", "v0"))
+	// the read pins the start of the watch, so that no change is lost
+	_, err := memory.Get(context.Background(), "/")
+	require.NoError(t, err)
+
+	recording := &bytes.Buffer{}
+	recorder := NewRecordingRegistry(memory, recording)
+
+	done := make(chan []string)
+	go func() {
+		done <- consumeServices(t, recorder, 3)
+	}()
+
+	// the changes are made concurrently, so a read may already see the value of a later event
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, memory.Set("/services/cas/one", fmt.Sprintf("v%d", i)))
+	}
+	recorded := <-done
+
+	replay, err := NewReplayRegistry(bytes.NewReader(recording.Bytes()))
+	require.NoError(t, err)
+	replay.timeout = 200 * time.Millisecond
+
+	assert.Equal(t, recorded, consumeServices(t, replay, 3))
+}
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"context"
+	"expvar"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v2"
+)
+
+// watchRetries counts the retries of failed watches by the class of the error
+var watchRetries = expvar.NewMap("registry_watch_retries")
+
+// RetryPolicy configures the delay between the attempts to restart a failed watch. The delay starts with the initial
+// delay and is multiplied with the multiplier after each failed attempt, until the maximum delay is reached. Jitter
+// is the fraction of the delay by which each delay is randomly shortened or extended, a jitter of 0 disables the
+// randomization and an unset jitter uses the default.
+type RetryPolicy struct {
+	InitialDelay time.Duration `yaml:"initial-delay"`
+	MaxDelay     time.Duration `yaml:"max-delay"`
+	Multiplier   float64
+	Jitter       *float64
+}
+
+// defaultJitter is the jitter of the DefaultRetryPolicy
+var defaultJitter = 0.2
+
+// DefaultRetryPolicy is used for all values which are not configured
+var DefaultRetryPolicy = RetryPolicy{
+	InitialDelay: time.Second,
+	MaxDelay:     time.Minute * 2,
+	Multiplier:   2,
+	Jitter:       &defaultJitter,
+}
+
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = DefaultRetryPolicy.InitialDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if policy.Jitter == nil || *policy.Jitter < 0 || *policy.Jitter > 1 {
+		policy.Jitter = DefaultRetryPolicy.Jitter
+	}
+	return policy
+}
+
+// backoff calculates the delays of consecutive failed attempts
+type backoff struct {
+	policy  RetryPolicy
+	attempt int
+	random  func() float64
+}
+
+func newBackoff(policy RetryPolicy) *backoff {
+	return &backoff{policy: policy.withDefaults(), random: rand.Float64}
+}
+
+// next returns the delay for the next attempt and increments the attempt counter
+func (b *backoff) next() time.Duration {
+	delay := float64(b.policy.InitialDelay) * math.Pow(b.policy.Multiplier, float64(b.attempt))
+	delay = math.Min(delay, float64(b.policy.MaxDelay))
+	b.attempt++
+
+	// random value between -jitter and +jitter
+	deviation := *b.policy.Jitter * (2*b.random() - 1)
+	return time.Duration(delay * (1 + deviation))
+}
+
+// reset starts the next failure with the initial delay again
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// watchError classifies the errors of a watch
+type watchError int
+
+const (
+	// watchErrorUnknown is an unexpected error e.g. a malformed response
+	watchErrorUnknown watchError = iota
+	// watchErrorUnavailable means that no member of the cluster could be reached
+	watchErrorUnavailable
+	// watchErrorCluster means that the cluster is reachable, but cannot serve the watch e.g. during a leader election
+	watchErrorCluster
+	// watchErrorUnauthorized means that the credentials were rejected
+	watchErrorUnauthorized
+	// watchErrorIndexCleared means that the index of the watch is outdated and events were lost
+	watchErrorIndexCleared
+)
+
+func (class watchError) String() string {
+	switch class {
+	case watchErrorUnavailable:
+		return "unavailable"
+	case watchErrorCluster:
+		return "cluster"
+	case watchErrorUnauthorized:
+		return "unauthorized"
+	case watchErrorIndexCleared:
+		return "index-cleared"
+	default:
+		return "unknown"
+	}
+}
+
+func classifyWatchError(err error) watchError {
+	var clusterError *client.ClusterError
+	if errors.As(err, &clusterError) || errors.Is(err, client.ErrClusterUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return watchErrorUnavailable
+	}
+
+	switch {
+	case errors.Is(err, rpctypes.ErrCompacted):
+		return watchErrorIndexCleared
+	case errors.Is(err, rpctypes.ErrPermissionDenied), errors.Is(err, rpctypes.ErrInvalidAuthToken), errors.Is(err, rpctypes.ErrAuthOldRevision):
+		return watchErrorUnauthorized
+	case errors.Is(err, rpctypes.ErrNoLeader), errors.Is(err, rpctypes.ErrLeaderChanged), errors.Is(err, rpctypes.ErrTimeout):
+		return watchErrorCluster
+	}
+
+	var consulError *consulStatusError
+	if errors.As(err, &consulError) {
+		switch {
+		case consulError.StatusCode == http.StatusUnauthorized, consulError.StatusCode == http.StatusForbidden:
+			return watchErrorUnauthorized
+		case consulError.StatusCode >= http.StatusInternalServerError:
+			return watchErrorCluster
+		}
+		return watchErrorUnknown
+	}
+
+	// the request of a http backend has not reached the server
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		return watchErrorUnavailable
+	}
+
+	var etcdError client.Error
+	if errors.As(err, &etcdError) {
+		switch etcdError.Code {
+		case client.ErrorCodeEventIndexCleared:
+			return watchErrorIndexCleared
+		case client.ErrorCodeUnauthorized:
+			return watchErrorUnauthorized
+		case client.ErrorCodeRaftInternal, client.ErrorCodeLeaderElect, client.ErrorCodeWatcherCleared:
+			return watchErrorCluster
+		}
+	}
+
+	return watchErrorUnknown
+}
@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v2"
+)
+
+func TestBackoff_next(t *testing.T) {
+	t.Run("should multiply delay until max delay is reached", func(t *testing.T) {
+		retry := newBackoff(RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2, Jitter: jitter(0.5)})
+		// without deviation
+		retry.random = func() float64 { return 0.5 }
+
+		assert.Equal(t, time.Second, retry.next())
+		assert.Equal(t, 2*time.Second, retry.next())
+		assert.Equal(t, 4*time.Second, retry.next())
+		assert.Equal(t, 5*time.Second, retry.next())
+		assert.Equal(t, 5*time.Second, retry.next())
+	})
+
+	t.Run("should start with initial delay after reset", func(t *testing.T) {
+		retry := newBackoff(RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2, Jitter: jitter(0.5)})
+		retry.random = func() float64 { return 0.5 }
+
+		retry.next()
+		retry.next()
+		retry.reset()
+		assert.Equal(t, time.Second, retry.next())
+	})
+
+	t.Run("should apply jitter", func(t *testing.T) {
+		retry := newBackoff(RetryPolicy{InitialDelay: 10 * time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: jitter(0.5)})
+
+		retry.random = func() float64 { return 0 }
+		assert.Equal(t, 5*time.Second, retry.next())
+
+		retry.reset()
+		retry.random = func() float64 { return 1 }
+		assert.Equal(t, 15*time.Second, retry.next())
+	})
+
+	t.Run("should not randomize delays without jitter", func(t *testing.T) {
+		retry := newBackoff(RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: jitter(0)})
+		retry.random = func() float64 { return 1 }
+
+		assert.Equal(t, time.Second, retry.next())
+		assert.Equal(t, 2*time.Second, retry.next())
+	})
+
+	t.Run("should use default for invalid jitter", func(t *testing.T) {
+		retry := newBackoff(RetryPolicy{Jitter: jitter(1.5)})
+		assert.Equal(t, 0.2, *retry.policy.Jitter)
+	})
+
+	t.Run("should use defaults for missing values", func(t *testing.T) {
+		retry := newBackoff(RetryPolicy{InitialDelay: 3 * time.Second})
+
+		assert.Equal(t, 3*time.Second, retry.policy.InitialDelay)
+		assert.Equal(t, DefaultRetryPolicy.MaxDelay, retry.policy.MaxDelay)
+		assert.Equal(t, DefaultRetryPolicy.Multiplier, retry.policy.Multiplier)
+		assert.Equal(t, 0.2, *retry.policy.Jitter)
+	})
+}
+
+func jitter(value float64) *float64 {
+	return &value
+}
+
+func TestClassifyWatchError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected watchError
+	}{
+		{"cluster error", &client.ClusterError{Errors: []error{assert.AnError}}, watchErrorUnavailable},
+		{"cluster unavailable", client.ErrClusterUnavailable, watchErrorUnavailable},
+		{"wrapped cluster error", errors.Wrap(&client.ClusterError{}, "watch failed"), watchErrorUnavailable},
+		{"event index cleared", client.Error{Code: client.ErrorCodeEventIndexCleared}, watchErrorIndexCleared},
+		{"leader election", client.Error{Code: client.ErrorCodeLeaderElect}, watchErrorCluster},
+		{"unauthorized", client.Error{Code: client.ErrorCodeUnauthorized}, watchErrorUnauthorized},
+		{"v3 compacted", rpctypes.ErrCompacted, watchErrorIndexCleared},
+		{"v3 no leader", rpctypes.ErrNoLeader, watchErrorCluster},
+		{"v3 permission denied", rpctypes.ErrPermissionDenied, watchErrorUnauthorized},
+		{"consul acl denied", &consulStatusError{StatusCode: 403}, watchErrorUnauthorized},
+		{"consul no leader", errors.Wrap(&consulStatusError{StatusCode: 500}, "failed to read key"), watchErrorCluster},
+		{"consul bad request", &consulStatusError{StatusCode: 400}, watchErrorUnknown},
+		{"http connection refused", &url.Error{Op: "Get", URL: "http://localhost:8500", Err: assert.AnError}, watchErrorUnavailable},
+		{"unknown", assert.AnError, watchErrorUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, classifyWatchError(test.err))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig configures the tls connection to the registry. The connection uses the system roots if no ca file is
+// configured and authenticates with a client certificate if cert and key file are configured.
+type TLSConfig struct {
+	CAFile     string `yaml:"ca-file"`
+	CertFile   string `yaml:"cert-file"`
+	KeyFile    string `yaml:"key-file"`
+	ServerName string `yaml:"server-name"`
+}
+
+// IsEnabled returns true if any tls option is configured
+func (config TLSConfig) IsEnabled() bool {
+	return config.CAFile != "" || config.CertFile != "" || config.KeyFile != "" || config.ServerName != ""
+}
+
+// clientConfig creates the tls configuration for the registry clients or nil, if tls is not configured
+func (config TLSConfig) clientConfig() (*tls.Config, error) {
+	if !config.IsEnabled() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+	}
+
+	if config.CAFile != "" {
+		data, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read ca file %s", config.CAFile)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("ca file %s does not contain a pem encoded certificate", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, errors.New("client certificate requires cert-file and key-file")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load client certificate %s", config.CertFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate creates a self signed certificate and writes the certificate and its key as pem files
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSConfig_clientConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+
+	t.Run("should return nil without tls options", func(t *testing.T) {
+		tlsConfig, err := TLSConfig{}.clientConfig()
+		require.NoError(t, err)
+		assert.Nil(t, tlsConfig)
+	})
+
+	t.Run("should load ca, client certificate and server name", func(t *testing.T) {
+		config := TLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "etcd.ces.local"}
+		tlsConfig, err := config.clientConfig()
+		require.NoError(t, err)
+		assert.NotNil(t, tlsConfig.RootCAs)
+		assert.Len(t, tlsConfig.Certificates, 1)
+		assert.Equal(t, "etcd.ces.local", tlsConfig.ServerName)
+	})
+
+	t.Run("should use system roots without ca file", func(t *testing.T) {
+		tlsConfig, err := TLSConfig{ServerName: "etcd.ces.local"}.clientConfig()
+		require.NoError(t, err)
+		assert.Nil(t, tlsConfig.RootCAs)
+	})
+
+	t.Run("should fail for ca file without certificate", func(t *testing.T) {
+		_, err := TLSConfig{CAFile: keyFile}.clientConfig()
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail for missing ca file", func(t *testing.T) {
+		_, err := TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}.clientConfig()
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail for client certificate without key", func(t *testing.T) {
+		_, err := TLSConfig{CertFile: certFile}.clientConfig()
+		assert.Error(t, err)
+	})
+}
+
+func TestNew_InvalidTLS(t *testing.T) {
+	config := Config{Endpoints: []string{"https://localhost:2379"}, TLS: TLSConfig{CertFile: "/does/not/exist.pem"}}
+
+	_, err := New(context.Background(), config)
+	assert.Error(t, err)
+
+	config.Backend = BackendEtcdV3
+	_, err = New(context.Background(), config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package reload
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// clockTicks is the unit of the start time in /proc/<pid>/stat, it is fixed to 100 for user space
+const clockTicks = 100
+
+// processStart returns the start time of the process
+func processStart(pid int) (time.Time, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the name in the second field may contain spaces and parentheses, the fields after its closing parenthesis
+	// start with the third field and the start time is the 22nd field
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, errors.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "unexpected start time in /proc/%d/stat", pid)
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks), nil
+}
+
+// bootTime returns the boot time of the system from /proc/stat
+func bootTime() (time.Time, error) {
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, errors.Wrap(err, "unexpected boot time in /proc/stat")
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("boot time is missing in /proc/stat")
+}
+
+// processName returns the name of the executable of the process. The executable of a process of another user cannot
+// be read without privileges, in this case the truncated name of /proc/<pid>/comm is returned.
+func processName(pid int) (string, error) {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err == nil {
+		// the executable of a running process may have been replaced by an update
+		return filepath.Base(strings.TrimSuffix(exe, " (deleted)")), nil
+	}
+
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package reload
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload_Send_reusedPid(t *testing.T) {
+	startSleep := func(t *testing.T) *exec.Cmd {
+		cmd := exec.Command("sleep", "5")
+		require.NoError(t, cmd.Start())
+		t.Cleanup(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+		return cmd
+	}
+
+	// assertRunning checks that the process was not terminated by the HUP
+	assertRunning := func(t *testing.T, cmd *exec.Cmd) {
+		time.Sleep(100 * time.Millisecond)
+		alive, err := isAlive(cmd.Process.Pid)
+		require.NoError(t, err)
+		assert.True(t, alive)
+	}
+
+	t.Run("should skip process which was started after the pid file was written", func(t *testing.T) {
+		cmd := startSleep(t)
+		pidFile := writePidFile(t, strconv.Itoa(cmd.Process.Pid))
+		written := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(pidFile, written, written))
+
+		require.NoError(t, Reload{PidFile: pidFile}.Send())
+		assertRunning(t, cmd)
+	})
+
+	t.Run("should skip process with another name", func(t *testing.T) {
+		cmd := startSleep(t)
+		reload := Reload{PidFile: writePidFile(t, strconv.Itoa(cmd.Process.Pid)), Process: "nginx"}
+
+		require.NoError(t, reload.Send())
+		assertRunning(t, cmd)
+	})
+
+	t.Run("should send signal to process with expected name", func(t *testing.T) {
+		cmd := exec.Command("sleep", "5")
+		require.NoError(t, cmd.Start())
+		reload := Reload{PidFile: writePidFile(t, strconv.Itoa(cmd.Process.Pid)), Process: "sleep"}
+
+		require.NoError(t, reload.Send())
+		err := cmd.Wait()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "hangup")
+	})
+}
+
+func TestReload_isExpected_finishedProcess(t *testing.T) {
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	reload := Reload{PidFile: writePidFile(t, strconv.Itoa(cmd.Process.Pid)), Process: "true"}
+
+	expected, err := reload.isExpected(cmd.Process.Pid)
+	require.NoError(t, err)
+	assert.False(t, expected)
+}
+
+func TestProcessName(t *testing.T) {
+	name, err := processName(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, "reload.test", name)
+}
+
+func TestProcessStart(t *testing.T) {
+	started, err := processStart(os.Getpid())
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), started, time.Minute)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package reload
+
+import "time"
+
+// processStart is not supported without /proc, the pid is not checked
+func processStart(_ int) (time.Time, error) {
+	return time.Time{}, errUnsupported
+}
+
+// processName is not supported without /proc
+func processName(_ int) (string, error) {
+	return "", errUnsupported
+}
@@ -0,0 +1,184 @@
+// Package reload tells a running process, e.g. nginx, to reload its configuration by sending a signal to the pid,
+// which is read from the pid file of the process. It is a built-in alternative to a post-command like
+// "nginx -s reload".
+package reload
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSignal is sent, if no signal is configured
+const DefaultSignal = "HUP"
+
+const (
+	// startTolerance is the imprecision of the start time of a process, which is compared to the pid file
+	startTolerance = time.Second
+	// maxCommLength is the length of a process name, which is truncated by the kernel
+	maxCommLength = 15
+)
+
+// errUnsupported is returned on systems without the process information of /proc
+var errUnsupported = errors.New("process information is not supported")
+
+// Reload sends the signal to the process of the pid file. A missing pid file or a stale pid is not an error: the
+// process reads the new configuration when it is started again. A pid is stale, if its process is no longer running
+// or if the pid was reused by another process, which was started after the pid file was written or whose executable
+// does not match the configured process name.
+type Reload struct {
+	PidFile string `yaml:"pid-file"`
+	Signal  string
+	// Process is the name of the executable, e.g. nginx, which is expected for the pid
+	Process string
+}
+
+// Enabled returns true, if a pid file is configured
+func (reload Reload) Enabled() bool {
+	return reload.PidFile != ""
+}
+
+// Validate checks the signal, so that an invalid configuration is rejected before the first target is written
+func (reload Reload) Validate() error {
+	if !reload.Enabled() {
+		return nil
+	}
+	_, err := parseSignal(reload.Signal)
+	return err
+}
+
+// Send reads the pid from the pid file and sends the signal to the process
+func (reload Reload) Send() error {
+	sig, err := parseSignal(reload.Signal)
+	if err != nil {
+		return err
+	}
+
+	pid, err := readPid(reload.PidFile)
+	if os.IsNotExist(errors.Cause(err)) {
+		log.Printf("pid file %s does not exist, skip reload", reload.PidFile)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	alive, err := isAlive(pid)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check process %d of pid file %s", pid, reload.PidFile)
+	}
+	if !alive {
+		log.Printf("process %d of pid file %s is not running, skip reload", pid, reload.PidFile)
+		return nil
+	}
+
+	expected, err := reload.isExpected(pid)
+	if err != nil {
+		return err
+	}
+	if !expected {
+		return nil
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find process %d of pid file %s", pid, reload.PidFile)
+	}
+
+	log.Printf("send %s to process %d of pid file %s", signalName(reload.Signal), pid, reload.PidFile)
+	err = process.Signal(sig)
+	if errors.Is(err, os.ErrProcessDone) {
+		log.Printf("process %d of pid file %s has finished, skip reload", pid, reload.PidFile)
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to send %s to process %d of pid file %s", signalName(reload.Signal), pid, reload.PidFile)
+	}
+	return nil
+}
+
+// isExpected checks, whether the process of the pid is the one, which wrote the pid file. The checks are skipped on
+// systems without the process information of /proc, a process which has finished in the meantime is not expected.
+func (reload Reload) isExpected(pid int) (bool, error) {
+	info, err := os.Stat(reload.PidFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to stat pid file %s", reload.PidFile)
+	}
+
+	started, err := processStart(pid)
+	if errors.Is(err, errUnsupported) {
+		return true, nil
+	} else if os.IsNotExist(errors.Cause(err)) {
+		log.Printf("process %d of pid file %s has finished, skip reload", pid, reload.PidFile)
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "failed to read start time of process %d", pid)
+	}
+	if started.After(info.ModTime().Add(startTolerance)) {
+		log.Printf("process %d was started at %s after pid file %s was written, skip reload of reused pid",
+			pid, started.Format(time.RFC3339), reload.PidFile)
+		return false, nil
+	}
+
+	if reload.Process == "" {
+		return true, nil
+	}
+	name, err := processName(pid)
+	if os.IsNotExist(errors.Cause(err)) {
+		log.Printf("process %d of pid file %s has finished, skip reload", pid, reload.PidFile)
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "failed to read name of process %d", pid)
+	}
+	if !matchesName(name, reload.Process) {
+		log.Printf("process %d of pid file %s is %s instead of %s, skip reload of reused pid", pid, reload.PidFile, name, reload.Process)
+		return false, nil
+	}
+	return true, nil
+}
+
+// matchesName compares the name of the process with the expected name. The name of a process, whose executable
+// cannot be read, is truncated to 15 characters by the kernel.
+func matchesName(name string, expected string) bool {
+	if len(name) == maxCommLength && len(expected) > maxCommLength {
+		expected = expected[:maxCommLength]
+	}
+	return name == expected
+}
+
+// readPid reads the pid from the first line of the pid file
+func readPid(pidFile string) (int, error) {
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read pid file %s", pidFile)
+	}
+
+	value := strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "pid file %s contains no pid", pidFile)
+	}
+	if pid <= 0 {
+		return 0, errors.Errorf("pid file %s contains invalid pid %d", pidFile, pid)
+	}
+	return pid, nil
+}
+
+// signalName returns the upper case name of the signal without SIG prefix
+func signalName(name string) string {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if name == "" {
+		return DefaultSignal
+	}
+	return name
+}
+
+func parseSignal(name string) (os.Signal, error) {
+	sig, ok := signals[signalName(name)]
+	if !ok {
+		return nil, errors.Errorf("unsupported reload signal %s", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,117 @@
+//go:build unix
+
+package reload
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startProcess starts a shell, which writes a line to the returned file for every received SIGUSR1
+func startProcess(t *testing.T) (*exec.Cmd, string) {
+	received := filepath.Join(t.TempDir(), "received")
+	cmd := exec.Command("/bin/sh", "-c", `trap 'echo usr1 >> `+received+`' USR1; while true; do sleep 0.05; done`)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	// give the shell time to install the trap
+	time.Sleep(200 * time.Millisecond)
+	return cmd, received
+}
+
+func writePidFile(t *testing.T, content string) string {
+	pidFile := filepath.Join(t.TempDir(), "nginx.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(content), 0644))
+	return pidFile
+}
+
+func TestReload_Send(t *testing.T) {
+	t.Run("should send signal to process of pid file", func(t *testing.T) {
+		cmd, received := startProcess(t)
+		reload := Reload{PidFile: writePidFile(t, strconv.Itoa(cmd.Process.Pid)+"\n"), Signal: "SIGUSR1"}
+
+		require.NoError(t, reload.Send())
+
+		assert.Eventually(t, func() bool {
+			content, err := os.ReadFile(received)
+			return err == nil && string(content) == "usr1\n"
+		}, 2*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("should send HUP by default", func(t *testing.T) {
+		cmd := exec.Command("sleep", "5")
+		require.NoError(t, cmd.Start())
+
+		reload := Reload{PidFile: writePidFile(t, strconv.Itoa(cmd.Process.Pid))}
+		require.NoError(t, reload.Send())
+
+		// sleep has no handler for HUP and is terminated
+		err := cmd.Wait()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "hangup")
+	})
+
+	t.Run("should skip missing pid file", func(t *testing.T) {
+		reload := Reload{PidFile: filepath.Join(t.TempDir(), "missing.pid")}
+		assert.NoError(t, reload.Send())
+	})
+
+	t.Run("should skip stale pid", func(t *testing.T) {
+		cmd := exec.Command("true")
+		require.NoError(t, cmd.Run())
+
+		reload := Reload{PidFile: writePidFile(t, strconv.Itoa(cmd.Process.Pid))}
+		assert.NoError(t, reload.Send())
+	})
+
+	t.Run("should fail for invalid pid file", func(t *testing.T) {
+		reload := Reload{PidFile: writePidFile(t, "nginx")}
+		err := reload.Send()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "contains no pid")
+
+		reload = Reload{PidFile: writePidFile(t, "-1")}
+		err = reload.Send()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid pid -1")
+	})
+
+	t.Run("should fail for unsupported signal", func(t *testing.T) {
+		reload := Reload{PidFile: writePidFile(t, "1"), Signal: "KILL"}
+		err := reload.Send()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported reload signal KILL")
+	})
+}
+
+func TestReload_Enabled(t *testing.T) {
+	assert.False(t, Reload{Signal: "HUP"}.Enabled())
+	assert.True(t, Reload{PidFile: "/run/nginx.pid"}.Enabled())
+}
+
+func TestReload_Validate(t *testing.T) {
+	assert.NoError(t, Reload{}.Validate())
+	assert.NoError(t, Reload{Signal: "KILL"}.Validate(), "disabled reload is not validated")
+	assert.NoError(t, Reload{PidFile: "/run/nginx.pid"}.Validate())
+	assert.NoError(t, Reload{PidFile: "/run/nginx.pid", Signal: "sigusr1"}.Validate())
+
+	err := Reload{PidFile: "/run/nginx.pid", Signal: "KILL"}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported reload signal KILL")
+}
+
+func Test_matchesName(t *testing.T) {
+	assert.True(t, matchesName("nginx", "nginx"))
+	assert.False(t, matchesName("sleep", "nginx"))
+	assert.True(t, matchesName("ces-confd-helpe", "ces-confd-helper"), "truncated name of /proc/<pid>/comm")
+	assert.False(t, matchesName("ces-confd", "ces-confd-helper"))
+}
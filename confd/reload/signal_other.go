@@ -0,0 +1,13 @@
+//go:build !unix
+
+package reload
+
+import "os"
+
+// signals is empty on systems without unix signals, reloads have to use a post-command
+var signals = map[string]os.Signal{}
+
+// isAlive leaves the check to the signal on systems without unix signals
+func isAlive(_ int) (bool, error) {
+	return true, nil
+}
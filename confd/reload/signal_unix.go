@@ -0,0 +1,29 @@
+//go:build unix
+
+package reload
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// signals are the signals, which can be configured for a reload
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// isAlive checks with the null signal, whether the process exists. A process of another user exists, even if the
+// check is not permitted.
+func isAlive(pid int) (bool, error) {
+	err := syscall.Kill(pid, 0)
+	if err == nil || errors.Is(err, syscall.EPERM) {
+		return true, nil
+	} else if errors.Is(err, syscall.ESRCH) {
+		return false, nil
+	}
+	return false, err
+}
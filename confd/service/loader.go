@@ -1,17 +1,18 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 
 	"github.com/cloudogu/ces-confd/confd"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/pkg/errors"
-	"go.etcd.io/etcd/client/v2"
 )
 
 type configRegistry interface {
-	Get(key string) (*client.Response, error)
-	Watch(key string, recursive bool, eventChannel chan *client.Response)
+	Get(ctx context.Context, key string) (*confRegistry.Node, error)
+	Watch(ctx context.Context, key string, recursive bool, eventChannel chan *confRegistry.Event)
 }
 
 type Loader struct {
@@ -20,9 +21,14 @@ type Loader struct {
 	writer   Writer
 }
 
-func (l *Loader) ReloadServices() {
+func (l *Loader) ReloadServices(ctx context.Context) {
 	log.Println("reload services from etcd")
-	templateModel, err := l.createTemplateModel()
+
+	// all keys of a rebuild are read from the same state of the registry
+	snapshot := *l
+	snapshot.registry = confRegistry.Snapshot(l.registry)
+
+	templateModel, err := snapshot.createTemplateModel(ctx)
 	if err != nil {
 		log.Printf("failed to reload services: %v", err)
 		return
@@ -30,33 +36,37 @@ func (l *Loader) ReloadServices() {
 
 	log.Printf("write services to template: %v", templateModel)
 
-	if err := l.writer.WriteTemplate(templateModel); err != nil {
+	if err := l.writer.WriteTemplate(ctx, snapshot.registry, templateModel); err != nil {
 		log.Printf("error on writeTemplate: %s", err.Error())
 	}
 }
 
-func (l *Loader) HasServiceChanged(resp *client.Response) (bool, error) {
-	if !isDirectory(resp.Node) && isModificationAction(resp.Action) {
-		return l.isServiceResponse(resp)
+func (l *Loader) HasServiceChanged(ctx context.Context, event *confRegistry.Event) (bool, error) {
+	// changes may have been lost, so every service could have been changed
+	if event.IsResync() {
+		return true, nil
+	}
+	if !isDirectory(event.Node) && isModificationAction(event.Action) {
+		return l.isServiceEvent(ctx, event)
 	}
 	return false, nil
 }
 
-func (l *Loader) convertToServices(key string) (Services, error) {
-	resp, err := l.registry.Get(key)
+func (l *Loader) convertToServices(ctx context.Context, key string) (Services, error) {
+	node, err := l.registry.Get(ctx, key)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read service key %s from etcd", key)
 	}
 
-	services := l.convertChildNodesToServices(resp.Node.Nodes)
+	services := l.convertChildNodesToServices(ctx, node.Nodes)
 
 	return services, nil
 }
 
-func (l *Loader) convertChildNodesToServices(childNodes client.Nodes) Services {
+func (l *Loader) convertChildNodesToServices(ctx context.Context, childNodes confRegistry.Nodes) Services {
 	services := Services{}
 	for _, child := range childNodes {
-		service, err := l.convertToService(child.Value)
+		service, err := l.convertToService(ctx, child.Value)
 		if err != nil {
 			// do not fail, if a single service contains an invalid entry
 			log.Printf("failed to convert node %s to service: %v", child.Key, err)
@@ -68,7 +78,7 @@ func (l *Loader) convertChildNodesToServices(childNodes client.Nodes) Services {
 	return services
 }
 
-func (l *Loader) convertToService(value string) (*Service, error) {
+func (l *Loader) convertToService(ctx context.Context, value string) (*Service, error) {
 	raw := confd.RawData{}
 	err := json.Unmarshal([]byte(value), &raw)
 	if err != nil {
@@ -90,7 +100,7 @@ func (l *Loader) convertToService(value string) (*Service, error) {
 		raw["healthStatus"] = nil
 	}
 
-	service, err := createService(raw, l.registry)
+	service, err := createService(ctx, raw, l.registry)
 	if err != nil {
 		return nil, err
 	}
@@ -98,20 +108,20 @@ func (l *Loader) convertToService(value string) (*Service, error) {
 	return service, nil
 }
 
-func (l *Loader) createTemplateModel() (TemplateModel, error) {
+func (l *Loader) createTemplateModel(ctx context.Context) (TemplateModel, error) {
 	maintenanceMode := ""
-	resp, err := l.registry.Get(l.config.MaintenanceMode)
+	node, err := l.registry.Get(ctx, l.config.MaintenanceMode)
 
 	if err != nil {
-		if !client.IsKeyNotFound(err) {
+		if !confRegistry.IsKeyNotFound(err) {
 			return TemplateModel{}, errors.Wrapf(err, "could not determine state of maintenance mode")
 		}
 	} else {
-		log.Printf("Maintenance mode resp: %v", resp)
-		maintenanceMode = resp.Node.Value
+		log.Printf("Maintenance mode node: %v", node)
+		maintenanceMode = node.Value
 	}
 
-	services, err := l.serviceReader()
+	services, err := l.serviceReader(ctx)
 	if err != nil {
 		return TemplateModel{}, errors.Wrapf(err, "Could not read service %s", l.config.Source.Path)
 	}
@@ -121,17 +131,17 @@ func (l *Loader) createTemplateModel() (TemplateModel, error) {
 
 // serviceReader reads from etcd and converts the keys and value to service
 // struct, which can easily used for configuration templates
-func (l *Loader) serviceReader() (Services, error) {
-	resp, err := l.registry.Get(l.config.Source.Path)
+func (l *Loader) serviceReader(ctx context.Context) (Services, error) {
+	root, err := l.registry.Get(ctx, l.config.Source.Path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read root %s from etcd", l.config.Source.Path)
 	}
 
 	services := Services{}
-	for _, child := range resp.Node.Nodes {
+	for _, child := range root.Nodes {
 		// convertToServices returns only an error, if the root key could not be read.
 		// In this case we should return an too.
-		serviceEntries, err := l.convertToServices(child.Key)
+		serviceEntries, err := l.convertToServices(ctx, child.Key)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to convert node %s to service", child.Key)
 		}
@@ -142,8 +152,8 @@ func (l *Loader) serviceReader() (Services, error) {
 	return services, nil
 }
 
-func (l *Loader) isServiceResponse(resp *client.Response) (bool, error) {
-	service, err := l.isServiceNode(resp.Node)
+func (l *Loader) isServiceEvent(ctx context.Context, event *confRegistry.Event) (bool, error) {
+	service, err := l.isServiceNode(ctx, event.Node)
 	if err != nil {
 		return false, err
 	}
@@ -152,7 +162,7 @@ func (l *Loader) isServiceResponse(resp *client.Response) (bool, error) {
 		return true, nil
 	}
 
-	service, err = l.isServiceNode(resp.PrevNode)
+	service, err = l.isServiceNode(ctx, event.PrevNode)
 	if err != nil {
 		return false, err
 	}
@@ -164,7 +174,7 @@ func (l *Loader) isServiceResponse(resp *client.Response) (bool, error) {
 	return false, nil
 }
 
-func (l *Loader) isServiceNode(node *client.Node) (bool, error) {
+func (l *Loader) isServiceNode(ctx context.Context, node *confRegistry.Node) (bool, error) {
 	if node == nil {
 		return false, nil
 	}
@@ -173,7 +183,7 @@ func (l *Loader) isServiceNode(node *client.Node) (bool, error) {
 		return false, nil
 	}
 
-	service, err := l.convertToService(node.Value)
+	service, err := l.convertToService(ctx, node.Value)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to convert node to service")
 	}
@@ -1,42 +1,43 @@
 package service
 
 import (
+	"context"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
-	"go.etcd.io/etcd/client/v2"
 	"testing"
 )
 
 func TestConvertTotServicesShouldNotFailOnError(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
-	heartOfGold := &client.Node{
+	heartOfGold := &confRegistry.Node{
 		Key:   "/services/heartOfGold",
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"], \"attributes\":{\"location\":\"heartOfGoldLocation\"}}",
 	}
 
-	restaurantAtTheEndOfTheUniverse := &client.Node{
+	restaurantAtTheEndOfTheUniverse := &confRegistry.Node{
 		Key:   "/services/restaurantAtTheEndOfTheUniverse",
 		Value: "{\"name\": \"restaurantAtTheEndOfTheUniverse\", \"service\": \"8.8.4.4\", \"tags\": [\"webapp\"]}",
 	}
 
-	invalidService := &client.Node{
+	invalidService := &confRegistry.Node{
 		Key:   "/services/invalid",
 		Value: "{\"name\": \"invalid\", \"service\": \"8.8.4.4\", \"tags\": 42}",
 	}
 
-	childNodes := client.Nodes{heartOfGold, restaurantAtTheEndOfTheUniverse, invalidService}
-	services := loader.convertChildNodesToServices(childNodes)
+	childNodes := confRegistry.Nodes{heartOfGold, restaurantAtTheEndOfTheUniverse, invalidService}
+	services := loader.convertChildNodesToServices(context.Background(), childNodes)
 	assert.Equal(t, 2, len(services))
 }
 
 func TestConvertToService(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
-	service, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"],\"healthStatus\":\"healthy\",\"attributes\":{\"day\":\"Friday\",\"location\":\"heartOfGoldLocation\"}}")
+	service, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"],\"healthStatus\":\"healthy\",\"attributes\":{\"day\":\"Friday\",\"location\":\"heartOfGoldLocation\"}}")
 	require.Nil(t, err)
 	require.NotNil(t, service)
 	require.Equal(t, "healthy", service.HealthStatus)
@@ -44,9 +45,9 @@ func TestConvertToService(t *testing.T) {
 
 func TestConvertToServiceWithEmptyHealth(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
-	service, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}")
+	service, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}")
 	require.Nil(t, err)
 	require.NotNil(t, service)
 	require.Equal(t, "", service.HealthStatus)
@@ -54,9 +55,9 @@ func TestConvertToServiceWithEmptyHealth(t *testing.T) {
 
 func TestConvertToServiceWithIgnoredHealth(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp", IgnoreHealth: true}, registry: registry}
-	service, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"],\"healthStatus\":\"healthy\"}")
+	service, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"],\"healthStatus\":\"healthy\"}")
 	require.Nil(t, err)
 	require.NotNil(t, service)
 	require.Equal(t, "", service.HealthStatus)
@@ -64,16 +65,16 @@ func TestConvertToServiceWithIgnoredHealth(t *testing.T) {
 
 func TestConvertToServiceWithoutTag(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{}, registry: registry}
-	service, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\"}")
+	service, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\"}")
 	require.Nil(t, err)
 	require.NotNil(t, service)
 }
 
 func TestConvertToServiceWithoutTags(t *testing.T) {
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
-	service, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\"}")
+	service, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\"}")
 	require.Nil(t, err)
 	require.Nil(t, service)
 }
@@ -81,7 +82,7 @@ func TestConvertToServiceWithoutTags(t *testing.T) {
 func TestConvertToServiceWithOtherTag(t *testing.T) {
 
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
-	service, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"web\"]}")
+	service, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"web\"]}")
 	require.Nil(t, err)
 	require.Nil(t, service)
 }
@@ -89,27 +90,27 @@ func TestConvertToServiceWithOtherTag(t *testing.T) {
 func TestConvertToServiceWithNonArrayTags(t *testing.T) {
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
 
-	_, err := loader.convertToService("{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": 12}")
+	_, err := loader.convertToService(context.Background(), "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": 12}")
 	require.NotNil(t, err)
 }
 
 func TestHasServiceChanged(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
 
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action: "create",
 		Node:   &node,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.True(t, changed)
 }
@@ -117,137 +118,137 @@ func TestHasServiceChanged(t *testing.T) {
 func TestHasServiceChangedIgnoreDirectories(t *testing.T) {
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
 
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir: true,
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action: "create",
 		Node:   &node,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.False(t, changed)
 }
 
 func TestHasServiceChangedDeleteAction(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
 
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action: "delete",
 		Node:   &node,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.True(t, changed)
 }
 
 func TestHasServiceChangedUpdateAction(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	prevNode := client.Node{
+	prevNode := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.4.4\", \"tags\": [\"webapp\"]}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action:   "update",
 		Node:     &node,
 		PrevNode: &prevNode,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.True(t, changed)
 }
 
 func TestHasServiceChangedSetAction(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	prevNode := client.Node{
+	prevNode := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.4.4\", \"tags\": [\"webapp\"]}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action:   "set",
 		Node:     &node,
 		PrevNode: &prevNode,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.True(t, changed)
 }
 
 func TestHasServiceChangedSetPreviousNonService(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
 
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	prevNode := client.Node{
+	prevNode := confRegistry.Node{
 		Dir:   false,
 		Value: "{}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action:   "set",
 		Node:     &node,
 		PrevNode: &prevNode,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.True(t, changed)
 }
 
 func TestHasServiceChangedSetPreviousServiceToNonService(t *testing.T) {
 	registry := newMockConfigRegistry(t)
-	registry.On("Get", mock.Anything).Return(&client.Response{Node: &client.Node{Value: "on"}}, nil)
+	registry.On("Get", mock.Anything, mock.Anything).Return(&confRegistry.Node{Value: "on"}, nil)
 	loader := &Loader{config: Configuration{Tag: "webapp"}, registry: registry}
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{}",
 	}
 
-	prevNode := client.Node{
+	prevNode := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action:   "set",
 		Node:     &node,
 		PrevNode: &prevNode,
 	}
 
-	changed, err := loader.HasServiceChanged(&response)
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
 	require.Nil(t, err)
 	require.True(t, changed)
 }
@@ -255,40 +256,51 @@ func TestHasServiceChangedSetPreviousServiceToNonService(t *testing.T) {
 func TestHasServiceChangedSetNodeErrorButPreviousNodeIsFine(t *testing.T) {
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
 
-	node := client.Node{
+	node := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": 42}",
 	}
 
-	prevNode := client.Node{
+	prevNode := confRegistry.Node{
 		Dir:   false,
 		Value: "{\"name\": \"heartOfGold\", \"service\": \"8.8.8.8\", \"tags\": [\"webapp\"]}",
 	}
 
-	response := client.Response{
+	event := confRegistry.Event{
 		Action:   "set",
 		Node:     &node,
 		PrevNode: &prevNode,
 	}
 
-	_, err := loader.HasServiceChanged(&response)
+	_, err := loader.HasServiceChanged(context.Background(), &event)
 	require.NotNil(t, err)
 }
 
 func TestIsServiceNodeWithoutNode(t *testing.T) {
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
-	isService, err := loader.isServiceNode(nil)
+	isService, err := loader.isServiceNode(context.Background(), nil)
 	require.Nil(t, err)
 	require.False(t, isService)
 }
 
 func TestIsServiceNodeWithoutValue(t *testing.T) {
 	loader := &Loader{config: Configuration{Tag: "webapp"}}
-	node := &client.Node{
+	node := &confRegistry.Node{
 		Dir: false,
 	}
 
-	isService, err := loader.isServiceNode(node)
+	isService, err := loader.isServiceNode(context.Background(), node)
 	require.Nil(t, err)
 	require.False(t, isService)
 }
+
+func TestHasServiceChangedResync(t *testing.T) {
+	loader := &Loader{config: Configuration{Tag: "webapp"}}
+	event := confRegistry.Event{
+		Action: confRegistry.ActionResync,
+		Node:   &confRegistry.Node{Key: "/services", Dir: true},
+	}
+	changed, err := loader.HasServiceChanged(context.Background(), &event)
+	require.Nil(t, err)
+	require.True(t, changed)
+}
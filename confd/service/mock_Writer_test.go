@@ -2,7 +2,12 @@
 
 package service
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	template "github.com/cloudogu/ces-confd/confd/template"
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MockWriter is an autogenerated mock type for the Writer type
 type MockWriter struct {
@@ -17,17 +22,17 @@ func (_m *MockWriter) EXPECT() *MockWriter_Expecter {
 	return &MockWriter_Expecter{mock: &_m.Mock}
 }
 
-// WriteTemplate provides a mock function with given fields: services
-func (_m *MockWriter) WriteTemplate(services TemplateModel) error {
-	ret := _m.Called(services)
+// WriteTemplate provides a mock function with given fields: ctx, registry, services
+func (_m *MockWriter) WriteTemplate(ctx context.Context, registry template.KeyReader, services TemplateModel) error {
+	ret := _m.Called(ctx, registry, services)
 
 	if len(ret) == 0 {
 		panic("no return value specified for WriteTemplate")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(TemplateModel) error); ok {
-		r0 = rf(services)
+	if rf, ok := ret.Get(0).(func(context.Context, template.KeyReader, TemplateModel) error); ok {
+		r0 = rf(ctx, registry, services)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -41,14 +46,16 @@ type MockWriter_WriteTemplate_Call struct {
 }
 
 // WriteTemplate is a helper method to define mock.On call
+//   - ctx context.Context
+//   - registry template.KeyReader
 //   - services TemplateModel
-func (_e *MockWriter_Expecter) WriteTemplate(services interface{}) *MockWriter_WriteTemplate_Call {
-	return &MockWriter_WriteTemplate_Call{Call: _e.mock.On("WriteTemplate", services)}
+func (_e *MockWriter_Expecter) WriteTemplate(ctx interface{}, registry interface{}, services interface{}) *MockWriter_WriteTemplate_Call {
+	return &MockWriter_WriteTemplate_Call{Call: _e.mock.On("WriteTemplate", ctx, registry, services)}
 }
 
-func (_c *MockWriter_WriteTemplate_Call) Run(run func(services TemplateModel)) *MockWriter_WriteTemplate_Call {
+func (_c *MockWriter_WriteTemplate_Call) Run(run func(ctx context.Context, registry template.KeyReader, services TemplateModel)) *MockWriter_WriteTemplate_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(TemplateModel))
+		run(args[0].(context.Context), args[1].(template.KeyReader), args[2].(TemplateModel))
 	})
 	return _c
 }
@@ -58,7 +65,7 @@ func (_c *MockWriter_WriteTemplate_Call) Return(_a0 error) *MockWriter_WriteTemp
 	return _c
 }
 
-func (_c *MockWriter_WriteTemplate_Call) RunAndReturn(run func(TemplateModel) error) *MockWriter_WriteTemplate_Call {
+func (_c *MockWriter_WriteTemplate_Call) RunAndReturn(run func(context.Context, template.KeyReader, TemplateModel) error) *MockWriter_WriteTemplate_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -1,15 +1,26 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/cloudogu/ces-confd/confd"
+	"github.com/cloudogu/ces-confd/confd/output"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/reload"
+	"github.com/cloudogu/ces-confd/confd/template"
 	"github.com/pkg/errors"
-	"go.etcd.io/etcd/client/v2"
 	"log"
+	"path"
+	"sync"
+	"time"
 )
 
-var modificationActions = []string{"create", "delete", "update", "set"}
+// BufferingPath is the directory of the proxy buffering settings of the services, e.g.
+// /config/nginx/buffering/redmine disables the buffering of redmine with the value off
+const BufferingPath = "/config/nginx/buffering"
+
+var modificationActions = []string{confRegistry.ActionCreate, confRegistry.ActionDelete, confRegistry.ActionUpdate, confRegistry.ActionSet}
 
 // Services is a collection of service structs
 type Services []*Service
@@ -28,6 +39,8 @@ type Service struct {
 	Location       string   `json:"location"`
 	Rewrite        *Rewrite `json:"rewrite,omitempty"`
 	ProxyBuffering string   `json:"proxyBuffering,omitempty"`
+	// Attributes are the string attributes of the service in the registry, e.g. for filters in templates
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 // String returns a string representation of a service
@@ -42,31 +55,65 @@ type Source struct {
 
 // Configuration struct for the service part of ces-confd
 type Configuration struct {
-	Source          Source
-	MaintenanceMode string `yaml:"maintenance-mode"`
-	Target          string
-	Template        string
-	Tag             string
-	PreCommand      string `yaml:"pre-command"`
-	PostCommand     string `yaml:"post-command"`
-	Order           confd.Order
-	IgnoreHealth    bool `yaml:"ignore-health"`
+	Source             Source
+	MaintenanceMode    string `yaml:"maintenance-mode"`
+	Target             string
+	Template           string
+	TemplateEngine     template.Engine `yaml:"template-engine"`
+	Tag                string
+	PreCommand         string        `yaml:"pre-command"`
+	PreCommandTimeout  time.Duration `yaml:"pre-command-timeout"`
+	PostCommand        string        `yaml:"post-command"`
+	PostCommandTimeout time.Duration `yaml:"post-command-timeout"`
+	QuarantineDir      string        `yaml:"quarantine-dir"`
+	Reload             reload.Reload
+	Order              confd.Order
+	IgnoreHealth       bool `yaml:"ignore-health"`
+	Debounce           confd.Debounce
+	// Outputs are rendered from the same template model in addition to the template and target above
+	Outputs []output.Output
+}
+
+// outputs returns the template and target of the configuration followed by the additional outputs. Templates are
+// rendered with text/template, unless an output selects another engine.
+func (conf Configuration) outputs() []output.Output {
+	var outputs []output.Output
+	if conf.Target != "" {
+		outputs = append(outputs, output.Output{
+			Template:           conf.Template,
+			Target:             conf.Target,
+			TemplateEngine:     conf.TemplateEngine,
+			PreCommand:         conf.PreCommand,
+			PreCommandTimeout:  conf.PreCommandTimeout,
+			PostCommand:        conf.PostCommand,
+			PostCommandTimeout: conf.PostCommandTimeout,
+			QuarantineDir:      conf.QuarantineDir,
+			Reload:             conf.Reload,
+		})
+	}
+	outputs = append(outputs, conf.Outputs...)
+	return output.WithDefaultEngine(outputs, template.EngineText)
+}
+
+// Validate checks the outputs of the configuration
+func (conf Configuration) Validate() error {
+	return output.Validate(conf.outputs())
 }
 
-func getProxyBuffering(registry configRegistry, serviceName string) string {
+func getProxyBuffering(ctx context.Context, registry configRegistry, serviceName string) string {
 	if registry == nil {
 		log.Println("Registry is not defined. Falling back to default 'on'")
 		return "on"
 	}
-	enableBufferingResponse, _ := registry.Get(fmt.Sprintf("config/nginx/buffering/%s", serviceName))
-	keyIsUnset := enableBufferingResponse == nil || enableBufferingResponse.Node == nil
-	if keyIsUnset || enableBufferingResponse.Node.Value != "off" {
+	enableBufferingNode, _ := registry.Get(ctx, path.Join(BufferingPath, serviceName))
+	keyIsUnset := enableBufferingNode == nil
+	if keyIsUnset || enableBufferingNode.Value != "off" {
 		return "on"
 	}
 	return "off"
 }
 
-func createService(raw confd.RawData, registry configRegistry) (*Service, error) {
+func createService(ctx context.Context, raw confd.RawData, registry configRegistry) (*Service, error) {
 	service := raw.GetStringValue("service")
 	if service == "" {
 		return nil, nil
@@ -101,7 +148,8 @@ func createService(raw confd.RawData, registry configRegistry) (*Service, error)
 		HealthStatus:   healthStatus,
 		Location:       location,
 		Rewrite:        rule,
-		ProxyBuffering: getProxyBuffering(registry, name),
+		ProxyBuffering: getProxyBuffering(ctx, registry, name),
+		Attributes:     raw.GetAttributes(),
 	}, nil
 }
 
@@ -119,7 +167,7 @@ func hasTag(raw confd.RawData, tag string) (bool, error) {
 	return confd.Contains(tags, tag), nil
 }
 
-func isDirectory(node *client.Node) bool {
+func isDirectory(node *confRegistry.Node) bool {
 	return node.Dir
 }
 
@@ -127,57 +175,91 @@ func isModificationAction(action string) bool {
 	return confd.ContainsString(modificationActions, action)
 }
 
-func reloadServicesIfNecessary(loader *Loader, resp *client.Response) {
-	key := resp.Node.Key
-	changed, err := loader.HasServiceChanged(resp)
+// isServiceChange returns true, if the event requires a reload of the services
+func isServiceChange(ctx context.Context, loader *Loader, event *confRegistry.Event) bool {
+	key := event.Key()
+	changed, err := loader.HasServiceChanged(ctx, event)
 	if err != nil {
 		log.Printf("failed to check if the change is responsible for a service: %v", err)
-		loader.ReloadServices()
-		return
+		return true
 	}
 
-	action := resp.Action
+	action := event.Action
 
 	if changed {
 		log.Printf("service %s changed, action=%s", key, action)
-		loader.ReloadServices()
 	} else {
 		log.Printf("ignoring change to non service key %s with action %s", key, action)
 	}
+	return changed
 }
 
-// Run creates the configuration for the services and updates the configuration whenever a service changed
-func Run(conf Configuration, registry configRegistry) {
-	serviceChannel := make(chan *client.Response)
-	maintenanceChannel := make(chan *client.Response)
+// Run creates the configuration for the services and updates the configuration whenever a service changed. Run
+// returns after the context is cancelled and all watchers have finished their work.
+func Run(ctx context.Context, conf Configuration, registry configRegistry) {
+	output.CheckPreCommands(conf.outputs())
+
+	serviceChannel := make(chan *confRegistry.Event)
+	maintenanceChannel := make(chan *confRegistry.Event)
+	bufferingChannel := make(chan *confRegistry.Event)
 	loader := &Loader{
 		registry: registry,
 		config:   conf,
 		writer:   &CommandWriter{config: conf},
 	}
 
+	var watchers sync.WaitGroup
+	watchers.Add(3)
+
 	log.Println("starting service watcher")
 	go func() {
-		for {
-			loader.ReloadServices()
-			registry.Watch(conf.Source.Path, true, serviceChannel)
+		defer watchers.Done()
+		for ctx.Err() == nil {
+			loader.ReloadServices(ctx)
+			registry.Watch(ctx, conf.Source.Path, true, serviceChannel)
 		}
 	}()
 	log.Println("starting maintenance mode watcher")
 
 	go func() {
-		for {
+		defer watchers.Done()
+		for ctx.Err() == nil {
 			// TODO: necessary?
-			loader.ReloadServices()
-			registry.Watch(conf.MaintenanceMode, false, maintenanceChannel)
+			loader.ReloadServices(ctx)
+			registry.Watch(ctx, conf.MaintenanceMode, false, maintenanceChannel)
+		}
+	}()
+	log.Println("starting proxy buffering watcher")
+	go func() {
+		defer watchers.Done()
+		for ctx.Err() == nil {
+			registry.Watch(ctx, BufferingPath, true, bufferingChannel)
 		}
 	}()
+	debouncer := confd.NewDebouncer("service", conf.Debounce)
+	defer debouncer.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			watchers.Wait()
+			log.Println("stopped service watcher")
+			return
 		case <-maintenanceChannel:
-			loader.ReloadServices()
-		case resp := <-serviceChannel:
-			reloadServicesIfNecessary(loader, resp)
+			if debouncer.Trigger() {
+				loader.ReloadServices(ctx)
+			}
+		case event := <-bufferingChannel:
+			log.Printf("proxy buffering %s changed, action=%s", event.Key(), event.Action)
+			if debouncer.Trigger() {
+				loader.ReloadServices(ctx)
+			}
+		case event := <-serviceChannel:
+			if isServiceChange(ctx, loader, event) && debouncer.Trigger() {
+				loader.ReloadServices(ctx)
+			}
+		case <-debouncer.C():
+			log.Printf("reload services after %d changes", debouncer.Flush())
+			loader.ReloadServices(ctx)
 		}
 	}
 }
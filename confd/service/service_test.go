@@ -1,14 +1,21 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/cloudogu/ces-confd/confd"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/stretchr/testify/require"
-	"go.etcd.io/etcd/client/v2"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestServicesString(t *testing.T) {
@@ -40,7 +47,7 @@ func TestCreateService(t *testing.T) {
 		}
 		raw["attributes"] = attributes
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "heartOfGold", service.Name)
 		assert.Equal(t, "http://8.8.8.8", service.URL)
@@ -51,7 +58,7 @@ func TestCreateService(t *testing.T) {
 		raw := confd.RawData{}
 		raw["service"] = "8.8.8.8"
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		require.Nil(t, service)
 	})
@@ -60,7 +67,7 @@ func TestCreateService(t *testing.T) {
 		raw := confd.RawData{}
 		raw["name"] = "heartOfGold"
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		require.Nil(t, service)
 	})
@@ -69,7 +76,7 @@ func TestCreateService(t *testing.T) {
 		raw["name"] = false
 		raw["service"] = "8.8.8.8"
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		require.Nil(t, service)
 	})
@@ -78,7 +85,7 @@ func TestCreateService(t *testing.T) {
 		raw["name"] = "heartOfGold"
 		raw["service"] = false
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		require.Nil(t, service)
 	})
@@ -88,7 +95,7 @@ func TestCreateService(t *testing.T) {
 		raw["name"] = "heartOfGold"
 		raw["service"] = "8.8.8.8"
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "heartOfGold", service.Location)
 	})
@@ -98,7 +105,7 @@ func TestCreateService(t *testing.T) {
 		raw["service"] = "8.8.8.8"
 		raw["attributes"] = "location:heartOfGoldLocation"
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "heartOfGold", service.Location)
 	})
@@ -114,7 +121,7 @@ func TestCreateService(t *testing.T) {
 		}
 		raw["attributes"] = attributes
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "heartOfGold", service.Name)
 		assert.Equal(t, "http://8.8.8.8", service.URL)
@@ -129,7 +136,7 @@ func TestCreateService(t *testing.T) {
 		}
 		raw["attributes"] = attributes
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "elasticsearch", service.Rewrite.Pattern)
 		assert.Equal(t, "test", service.Rewrite.Rewrite)
@@ -138,7 +145,7 @@ func TestCreateService(t *testing.T) {
 	t.Run("should return created service with buffering on per default", func(t *testing.T) {
 		raw["name"] = "heartOfGold"
 		raw["service"] = "8.8.8.8"
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "on", service.ProxyBuffering)
 	})
@@ -147,8 +154,8 @@ func TestCreateService(t *testing.T) {
 		raw["name"] = "heartOfGold"
 		raw["service"] = "8.8.8.8"
 		registry := newMockConfigRegistry(t)
-		registry.On("Get", "config/nginx/buffering/heartOfGold").Return(&client.Response{Node: &client.Node{Value: "off"}}, nil)
-		service, err := createService(raw, registry)
+		registry.On("Get", mock.Anything, "/config/nginx/buffering/heartOfGold").Return(&confRegistry.Node{Value: "off"}, nil)
+		service, err := createService(context.Background(), raw, registry)
 		require.NoError(t, err)
 		assert.Equal(t, "off", service.ProxyBuffering)
 		registry.AssertExpectations(t)
@@ -162,7 +169,7 @@ func TestCreateService(t *testing.T) {
 		}
 		raw["attributes"] = attributes
 
-		_, err := createService(raw, nil)
+		_, err := createService(context.Background(), raw, nil)
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "failed to unmarshal rewrite rule")
 	})
@@ -172,7 +179,7 @@ func TestCreateService(t *testing.T) {
 		raw["service"] = "8.8.8.8"
 		raw["attributes"] = map[string]interface{}{}
 
-		service, err := createService(raw, nil)
+		service, err := createService(context.Background(), raw, nil)
 		require.NoError(t, err)
 		assert.Nil(t, service.Rewrite)
 	})
@@ -182,32 +189,242 @@ func Test_getProxyBuffering(t *testing.T) {
 	testerror := errors.New("testerror")
 	registry := newMockConfigRegistry(t)
 	t.Run("should return 'on' if registry is nil", func(t *testing.T) {
-		resp := getProxyBuffering(nil, "testservice")
+		resp := getProxyBuffering(context.Background(), nil, "testservice")
 		assert.Equal(t, "on", resp)
 	})
-	t.Run("should return 'on' if response is nil", func(t *testing.T) {
-		registry.On("Get", "config/nginx/buffering/testservice").Return(nil, testerror).Once()
-		resp := getProxyBuffering(registry, "testservice")
+	t.Run("should return 'on' if reading fails", func(t *testing.T) {
+		registry.On("Get", mock.Anything, "/config/nginx/buffering/testservice").Return(nil, testerror).Once()
+		resp := getProxyBuffering(context.Background(), registry, "testservice")
 		assert.Equal(t, "on", resp)
 	})
 	t.Run("should return 'on' if node is nil", func(t *testing.T) {
-		registry.On("Get", "config/nginx/buffering/testservice").Return(&client.Response{Node: nil}, nil).Once()
-		resp := getProxyBuffering(registry, "testservice")
+		registry.On("Get", mock.Anything, "/config/nginx/buffering/testservice").Return(nil, nil).Once()
+		resp := getProxyBuffering(context.Background(), registry, "testservice")
 		assert.Equal(t, "on", resp)
 	})
 	t.Run("should return 'on' if configured value is 'on'", func(t *testing.T) {
-		registry.On("Get", "config/nginx/buffering/testservice").Return(&client.Response{Node: &client.Node{Value: "on"}}, nil).Once()
-		resp := getProxyBuffering(registry, "testservice")
+		registry.On("Get", mock.Anything, "/config/nginx/buffering/testservice").Return(&confRegistry.Node{Value: "on"}, nil).Once()
+		resp := getProxyBuffering(context.Background(), registry, "testservice")
 		assert.Equal(t, "on", resp)
 	})
 	t.Run("should return 'off' if configured value is 'off'", func(t *testing.T) {
-		registry.On("Get", "config/nginx/buffering/testservice").Return(&client.Response{Node: &client.Node{Value: "off"}}, nil).Once()
-		resp := getProxyBuffering(registry, "testservice")
+		registry.On("Get", mock.Anything, "/config/nginx/buffering/testservice").Return(&confRegistry.Node{Value: "off"}, nil).Once()
+		resp := getProxyBuffering(context.Background(), registry, "testservice")
 		assert.Equal(t, "off", resp)
 	})
 	t.Run("should return default value 'on' if configured value in registry is neither 'on' or 'off'", func(t *testing.T) {
-		registry.On("Get", "config/nginx/buffering/testservice").Return(&client.Response{Node: &client.Node{Value: "gary"}}, nil).Once()
-		resp := getProxyBuffering(registry, "testservice")
+		registry.On("Get", mock.Anything, "/config/nginx/buffering/testservice").Return(&confRegistry.Node{Value: "gary"}, nil).Once()
+		resp := getProxyBuffering(context.Background(), registry, "testservice")
 		assert.Equal(t, "on", resp)
 	})
 }
+
+// countingRegistry records the keys which are read from the memory registry
+type countingRegistry struct {
+	*confRegistry.MemoryRegistry
+	mutex sync.Mutex
+	reads []string
+}
+
+func (r *countingRegistry) Get(ctx context.Context, key string) (*confRegistry.Node, error) {
+	r.mutex.Lock()
+	r.reads = append(r.reads, key)
+	r.mutex.Unlock()
+	return r.MemoryRegistry.Get(ctx, key)
+}
+
+func (r *countingRegistry) takeReads() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	reads := r.reads
+	r.reads = nil
+	return reads
+}
+
+func Test_getProxyBuffering_cached(t *testing.T) {
+	registry := &countingRegistry{MemoryRegistry: confRegistry.NewMemoryRegistry()}
+	require.NoError(t, registry.Set("/config/nginx/buffering/redmine", "off"))
+
+	cache := confRegistry.NewCachingRegistry(registry, confRegistry.RetryPolicy{}, "/services", BufferingPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// reads are passed to the registry until the mirror is loaded
+	require.Eventually(t, func() bool {
+		registry.takeReads()
+		snapshot := confRegistry.Snapshot(cache)
+		return getProxyBuffering(ctx, snapshot, "redmine") == "off" &&
+			getProxyBuffering(ctx, snapshot, "cas") == "on" &&
+			len(registry.takeReads()) == 0
+	}, 5*time.Second, 10*time.Millisecond, "proxy buffering is not read from the snapshot")
+
+	require.NoError(t, registry.Set("/config/nginx/buffering/redmine", "on"))
+	require.Eventually(t, func() bool {
+		return getProxyBuffering(ctx, confRegistry.Snapshot(cache), "redmine") == "on"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, registry.takeReads())
+}
+
+func TestRun(t *testing.T) {
+	t.Run("should return after the context is cancelled", func(t *testing.T) {
+		registry := newMockConfigRegistry(t)
+		registry.On("Get", mock.Anything, mock.Anything).Return(nil, &confRegistry.KeyNotFoundError{Key: "/services"}).Maybe()
+		registry.On("Watch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).Maybe()
+		conf := Configuration{Source: Source{Path: "/services"}, MaintenanceMode: "/config/_global/maintenance"}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			Run(ctx, conf, registry)
+			close(done)
+		}()
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not return after the context was cancelled")
+		}
+	})
+	t.Run("should render services and maintenance mode on changes", func(t *testing.T) {
+		dir := t.TempDir()
+		tpl := filepath.Join(dir, "app.conf.tpl")
+		target := filepath.Join(dir, "app.conf")
+		err := os.WriteFile(tpl, []byte("{{range .Services}}{{.Name}}={{.URL}};{{end}}maintenance={{.Maintenance}}"), 0644)
+		require.NoError(t, err)
+
+		registry := confRegistry.NewMemoryRegistry()
+		require.NoError(t, registry.Set("/services/cas/one", `{"name": "cas", "service": "10.0.0.1:8080", "tags": ["webapp"]}`))
+		conf := Configuration{
+			Source:          Source{Path: "/services"},
+			MaintenanceMode: "/config/_global/maintenance",
+			Target:          target,
+			Template:        tpl,
+			Tag:             "webapp",
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			Run(ctx, conf, registry)
+			close(done)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		requireTarget(t, target, "cas=http://10.0.0.1:8080;maintenance=")
+
+		require.NoError(t, registry.Set("/services/nginx/one", `{"name": "nginx", "service": "10.0.0.2:80", "tags": ["webapp"]}`))
+		requireTarget(t, target, "cas=http://10.0.0.1:8080;nginx=http://10.0.0.2:80;maintenance=")
+
+		require.NoError(t, registry.Set("/config/_global/maintenance", "on"))
+		requireTarget(t, target, "cas=http://10.0.0.1:8080;nginx=http://10.0.0.2:80;maintenance=on")
+
+		require.NoError(t, registry.Delete("/services/cas/one", false))
+		requireTarget(t, target, "nginx=http://10.0.0.2:80;maintenance=on")
+	})
+	t.Run("should render services on changes of the proxy buffering", func(t *testing.T) {
+		dir := t.TempDir()
+		tpl := filepath.Join(dir, "app.conf.tpl")
+		target := filepath.Join(dir, "app.conf")
+		err := os.WriteFile(tpl, []byte("{{range .Services}}{{.Name}}={{.ProxyBuffering}};{{end}}"), 0644)
+		require.NoError(t, err)
+
+		registry := confRegistry.NewMemoryRegistry()
+		require.NoError(t, registry.Set("/services/redmine/one", `{"name": "redmine", "service": "10.0.0.1:8080", "tags": ["webapp"]}`))
+		conf := Configuration{
+			Source:          Source{Path: "/services"},
+			MaintenanceMode: "/config/_global/maintenance",
+			Target:          target,
+			Template:        tpl,
+			Tag:             "webapp",
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			Run(ctx, conf, registry)
+			close(done)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		requireTarget(t, target, "redmine=on;")
+		require.NoError(t, registry.Set("/config/nginx/buffering/redmine", "off"))
+		requireTarget(t, target, "redmine=off;")
+	})
+	t.Run("should render the same file from a replay of a recording", func(t *testing.T) {
+		dir := t.TempDir()
+		tpl := filepath.Join(dir, "app.conf.tpl")
+		err := os.WriteFile(tpl, []byte("{{range .Services}}{{.Name}}={{.URL}};{{end}}maintenance={{.Maintenance}}"), 0644)
+		require.NoError(t, err)
+		conf := Configuration{
+			Source:          Source{Path: "/services"},
+			MaintenanceMode: "/config/_global/maintenance",
+			Target:          filepath.Join(dir, "recorded.conf"),
+			Template:        tpl,
+			Tag:             "webapp",
+		}
+
+		memory := confRegistry.NewMemoryRegistry()
+		require.NoError(t, memory.Set("/services/cas/one", `{"name": "cas", "service": "10.0.0.1:8080", "tags": ["webapp"]}`))
+		recording := &bytes.Buffer{}
+		recorder := confRegistry.NewRecordingRegistry(memory, recording)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			Run(ctx, conf, recorder)
+			close(done)
+		}()
+
+		requireTarget(t, conf.Target, "cas=http://10.0.0.1:8080;maintenance=")
+		require.NoError(t, memory.Set("/config/_global/maintenance", "on"))
+		requireTarget(t, conf.Target, "cas=http://10.0.0.1:8080;maintenance=on")
+		require.NoError(t, memory.Set("/services/cas/one", `{"name": "cas", "service": "10.0.0.3:8080", "tags": ["webapp"]}`))
+		requireTarget(t, conf.Target, "cas=http://10.0.0.3:8080;maintenance=on")
+		cancel()
+		<-done
+
+		replay, err := confRegistry.NewReplayRegistry(bytes.NewReader(recording.Bytes()))
+		require.NoError(t, err)
+		conf.Target = filepath.Join(dir, "replayed.conf")
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		done = make(chan struct{})
+		go func() {
+			Run(ctx, conf, replay)
+			close(done)
+		}()
+
+		replay.Wait(ctx)
+		cancel()
+		<-done
+
+		content, err := os.ReadFile(conf.Target)
+		require.NoError(t, err)
+		assert.Equal(t, "cas=http://10.0.0.3:8080;maintenance=on", string(content))
+	})
+}
+
+func requireTarget(t *testing.T, target string, expected string) {
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(target)
+		return err == nil && string(content) == expected
+	}, 5*time.Second, 10*time.Millisecond, "target does not contain %s", expected)
+}
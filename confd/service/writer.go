@@ -1,12 +1,26 @@
 package service
 
 import (
-	"html/template"
-	"log"
-	"os"
-	"path"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
-	"github.com/pkg/errors"
+	"github.com/cloudogu/ces-confd/confd/output"
+	"github.com/cloudogu/ces-confd/confd/template"
+)
+
+const (
+	// EnvServicesAdded is the environment variable with the space separated names of the services, which were added
+	// since the last render
+	EnvServicesAdded = "CES_CONFD_SERVICES_ADDED"
+	// EnvServicesRemoved is the environment variable with the space separated names of the services, which were
+	// removed since the last render
+	EnvServicesRemoved = "CES_CONFD_SERVICES_REMOVED"
+	// EnvServicesChanged is the environment variable with the space separated names of the services, which were
+	// changed since the last render
+	EnvServicesChanged = "CES_CONFD_SERVICES_CHANGED"
 )
 
 // TemplateModel is the input for the target template
@@ -15,60 +29,73 @@ type TemplateModel struct {
 	Services    Services
 }
 
+// Writer writes the template model to the target, the registry is used by the registry functions of the template
 type Writer interface {
-	WriteTemplate(services TemplateModel) error
+	WriteTemplate(ctx context.Context, registry template.KeyReader, services TemplateModel) error
 }
 
-// CommandWriter implements the writer interface and executes pre- and post-commands
+// CommandWriter implements the writer interface and executes pre- and post-commands. It remembers the services of
+// the last render, so that the commands can be told which services have changed.
 type CommandWriter struct {
-	config Configuration
+	config   Configuration
+	mutex    sync.Mutex
+	previous map[string]Services
 }
 
-// WriteServices transform the data with a golang template
-func (c *CommandWriter) WriteTemplate(data TemplateModel) error {
-	if c.config.PreCommand != "" {
-		err := preCheck(c.config, data)
-		if err != nil {
-			return errors.Wrap(err, "pre check failed")
-		}
-	}
+// WriteTemplate renders all outputs of the configuration with the data and writes them to their targets. If the
+// rendered content of an output equals its current target, the write and the pre- and post-commands of this output
+// are skipped. The commands receive the generator, the maintenance mode and the services, which have changed since
+// the last successful render, as environment variables.
+func (c *CommandWriter) WriteTemplate(ctx context.Context, registry template.KeyReader, data TemplateModel) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current := servicesByName(data.Services)
+	added, removed, changed := diffServices(c.previous, current)
 
-	err := write(c.config, data)
+	env := []string{
+		output.Env(output.EnvGenerator, "service"),
+		output.Env(output.EnvMaintenance, data.Maintenance),
+		output.Env(EnvServicesAdded, strings.Join(added, " ")),
+		output.Env(EnvServicesRemoved, strings.Join(removed, " ")),
+		output.Env(EnvServicesChanged, strings.Join(changed, " ")),
+	}
+	err := output.WriteAll(c.config.outputs(), data, template.Funcs(ctx, registry), env...)
 	if err != nil {
-		return errors.Wrap(err, "failed to write data")
+		return err
 	}
 
-	if c.config.PostCommand != "" {
-		err = post(c.config.PostCommand)
-		if err != nil {
-			return errors.Wrap(err, "post command failed")
-		}
-	}
+	c.previous = current
 	return nil
 }
 
-func write(config Configuration, data interface{}) error {
-	name := path.Base(config.Template)
-	tmpl, err := template.New(name).ParseFiles(config.Template)
-	if err != nil {
-		return errors.Wrap(err, "failed to parse template")
+// servicesByName groups the instances of the services by their name
+func servicesByName(services Services) map[string]Services {
+	byName := map[string]Services{}
+	for _, service := range services {
+		byName[service.Name] = append(byName[service.Name], service)
 	}
+	return byName
+}
 
-	file, err := os.Create(config.Target)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create target file %s", config.Target)
+// diffServices returns the sorted names of the added, removed and changed services
+func diffServices(previous map[string]Services, current map[string]Services) (added []string, removed []string, changed []string) {
+	for name, services := range current {
+		previousServices, ok := previous[name]
+		if !ok {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(previousServices, services) {
+			changed = append(changed, name)
+		}
 	}
-
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			log.Println("failed to close file")
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, name)
 		}
-	}()
-
-	err = tmpl.Execute(file, data)
-	if err != nil {
-		return errors.Wrap(err, "failed to render template")
 	}
-	return nil
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
 }
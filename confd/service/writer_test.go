@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudogu/ces-confd/confd/output"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/reload"
+	"github.com/cloudogu/ces-confd/confd/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandWriter_WriteTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tpl := filepath.Join(dir, "app.conf.tpl")
+	require.NoError(t, os.WriteFile(tpl, []byte("{{range .Services}}{{.Name}};{{end}}"), 0644))
+
+	target := filepath.Join(dir, "app.conf")
+	commands := filepath.Join(dir, "commands")
+	writer := &CommandWriter{config: Configuration{
+		Target:      target,
+		Template:    tpl,
+		PreCommand:  "echo pre >> " + commands,
+		PostCommand: "echo post >> " + commands,
+	}}
+
+	model := TemplateModel{Services: Services{{Name: "cas"}}}
+
+	t.Run("should write target and execute commands", func(t *testing.T) {
+		require.NoError(t, writer.WriteTemplate(context.Background(), nil, model))
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "cas;", string(content))
+
+		content, err = os.ReadFile(commands)
+		require.NoError(t, err)
+		assert.Equal(t, "pre\npost\n", string(content))
+	})
+
+	t.Run("should skip write and commands if the output is unchanged", func(t *testing.T) {
+		require.NoError(t, os.Remove(commands))
+		info, err := os.Stat(target)
+		require.NoError(t, err)
+
+		// e.g. a changed health status, which is not part of the template
+		changedHealth := TemplateModel{Services: Services{{Name: "cas", HealthStatus: "unhealthy"}}}
+		require.NoError(t, writer.WriteTemplate(context.Background(), nil, changedHealth))
+
+		_, err = os.Stat(commands)
+		assert.True(t, os.IsNotExist(err), "commands must not be executed")
+
+		unchanged, err := os.Stat(target)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(info, unchanged), "target must not be replaced")
+	})
+
+	t.Run("should write target and execute commands if the output has changed", func(t *testing.T) {
+		require.NoError(t, writer.WriteTemplate(context.Background(), nil, TemplateModel{Services: Services{{Name: "cas"}, {Name: "nginx"}}}))
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "cas;nginx;", string(content))
+
+		content, err = os.ReadFile(commands)
+		require.NoError(t, err)
+		assert.Equal(t, "pre\npost\n", string(content))
+	})
+}
+
+func TestConfiguration_outputs(t *testing.T) {
+	tpl := filepath.Join(t.TempDir(), "app.conf.tpl")
+	require.NoError(t, os.WriteFile(tpl, []byte("{{range .Services}}rewrite {{.Rewrite.Pattern}} {{nginxQuote .Rewrite.Rewrite}};{{end}}"), 0644))
+	model := TemplateModel{Services: Services{{Name: "cas", Rewrite: &Rewrite{Pattern: `^/cas/(.*)&a=<1>`, Rewrite: `/"$1"`}}}}
+
+	t.Run("should render with text/template by default", func(t *testing.T) {
+		outputs := Configuration{Template: tpl, Target: "app.conf"}.outputs()
+		require.Len(t, outputs, 1)
+		content, err := outputs[0].Render(model, template.Funcs(context.Background(), nil))
+		require.NoError(t, err)
+		assert.Equal(t, `rewrite ^/cas/(.*)&a=<1> "/\"$1\"";`, string(content))
+	})
+
+	t.Run("should render with configured engine", func(t *testing.T) {
+		outputs := Configuration{Template: tpl, Target: "app.conf", TemplateEngine: template.EngineHTML}.outputs()
+		content, err := outputs[0].Render(model, template.Funcs(context.Background(), nil))
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "&amp;a=&lt;1&gt;")
+	})
+
+	t.Run("should append additional outputs", func(t *testing.T) {
+		nginx := reload.Reload{PidFile: "/run/nginx.pid", Signal: "HUP"}
+		conf := Configuration{Template: tpl, Target: "app.conf", PostCommand: "nginx -s reload", Reload: nginx, Outputs: []output.Output{
+			{Template: "upstreams.tpl", Target: "upstreams.conf"},
+			{Template: "inventory.tpl", Target: "inventory.json", TemplateEngine: template.EngineHTML},
+		}}
+		assert.Equal(t, []output.Output{
+			{Template: tpl, Target: "app.conf", TemplateEngine: template.EngineText, PostCommand: "nginx -s reload", Reload: nginx},
+			{Template: "upstreams.tpl", Target: "upstreams.conf", TemplateEngine: template.EngineText},
+			{Template: "inventory.tpl", Target: "inventory.json", TemplateEngine: template.EngineHTML},
+		}, conf.outputs())
+	})
+
+	t.Run("should only use additional outputs without target", func(t *testing.T) {
+		conf := Configuration{Outputs: []output.Output{{Template: "upstreams.tpl", Target: "upstreams.conf"}}}
+		assert.Equal(t, []output.Output{{Template: "upstreams.tpl", Target: "upstreams.conf", TemplateEngine: template.EngineText}}, conf.outputs())
+	})
+}
+
+func TestCommandWriter_WriteTemplate_registryFunctions(t *testing.T) {
+	dir := t.TempDir()
+	tpl := filepath.Join(dir, "app.conf.tpl")
+	content := `{{range where "Attributes.auth" "cas" .Services}}{{.Name}} buffering={{getv (printf "/config/nginx/buffering/%s" .Name) "on"}};{{end}}`
+	require.NoError(t, os.WriteFile(tpl, []byte(content), 0644))
+
+	target := filepath.Join(dir, "app.conf")
+	writer := &CommandWriter{config: Configuration{Target: target, Template: tpl}}
+
+	registry := confRegistry.NewMemoryRegistry()
+	require.NoError(t, registry.Set("/config/nginx/buffering/redmine", "off"))
+	model := TemplateModel{Services: Services{
+		{Name: "cas"},
+		{Name: "redmine", Attributes: map[string]string{"auth": "cas"}},
+		{Name: "jenkins", Attributes: map[string]string{"auth": "cas"}},
+	}}
+
+	require.NoError(t, writer.WriteTemplate(context.Background(), registry, model))
+
+	written, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "redmine buffering=off;jenkins buffering=on;", string(written))
+}
+
+func TestCommandWriter_WriteTemplate_environment(t *testing.T) {
+	dir := t.TempDir()
+	tpl := filepath.Join(dir, "app.conf.tpl")
+	require.NoError(t, os.WriteFile(tpl, []byte("{{range .Services}}{{.Name}}={{.URL}};{{end}}{{.Maintenance}}"), 0644))
+
+	target := filepath.Join(dir, "app.conf")
+	environment := filepath.Join(dir, "environment")
+	writer := &CommandWriter{config: Configuration{
+		Target:      target,
+		Template:    tpl,
+		PostCommand: "env | grep ^CES_CONFD_ | sort > " + environment,
+	}}
+
+	readEnvironment := func() string {
+		content, err := os.ReadFile(environment)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	require.NoError(t, writer.WriteTemplate(context.Background(), nil, TemplateModel{Services: Services{
+		{Name: "cas", URL: "http://10.0.0.1"},
+		{Name: "nginx", URL: "http://10.0.0.2"},
+	}}))
+	assert.Equal(t, "CES_CONFD_GENERATOR=service\n"+
+		"CES_CONFD_MAINTENANCE=\n"+
+		"CES_CONFD_SERVICES_ADDED=cas nginx\n"+
+		"CES_CONFD_SERVICES_CHANGED=\n"+
+		"CES_CONFD_SERVICES_REMOVED=\n"+
+		"CES_CONFD_TARGET="+target+"\n", readEnvironment())
+
+	require.NoError(t, writer.WriteTemplate(context.Background(), nil, TemplateModel{Maintenance: "on", Services: Services{
+		{Name: "cas", URL: "http://10.0.0.3"},
+		{Name: "redmine", URL: "http://10.0.0.4"},
+	}}))
+	assert.Equal(t, "CES_CONFD_GENERATOR=service\n"+
+		"CES_CONFD_MAINTENANCE=on\n"+
+		"CES_CONFD_SERVICES_ADDED=redmine\n"+
+		"CES_CONFD_SERVICES_CHANGED=cas\n"+
+		"CES_CONFD_SERVICES_REMOVED=nginx\n"+
+		"CES_CONFD_TARGET="+target+"\n", readEnvironment())
+}
+
+func Test_diffServices(t *testing.T) {
+	previous := servicesByName(Services{
+		{Name: "cas", URL: "http://10.0.0.1"},
+		{Name: "nginx", URL: "http://10.0.0.2"},
+		{Name: "scm", URL: "http://10.0.0.5"},
+		{Name: "scm", URL: "http://10.0.0.6"},
+	})
+	current := servicesByName(Services{
+		{Name: "cas", URL: "http://10.0.0.1"},
+		{Name: "scm", URL: "http://10.0.0.5"},
+		{Name: "redmine", URL: "http://10.0.0.4"},
+	})
+
+	added, removed, changed := diffServices(previous, current)
+	assert.Equal(t, []string{"redmine"}, added)
+	assert.Equal(t, []string{"nginx"}, removed)
+	assert.Equal(t, []string{"scm"}, changed, "a removed instance changes the service")
+
+	added, removed, changed = diffServices(nil, current)
+	assert.Equal(t, []string{"cas", "redmine", "scm"}, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+}
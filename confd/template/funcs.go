@@ -0,0 +1,318 @@
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/pkg/errors"
+)
+
+// ConfigPrefix is the only part of the registry, which can be read from templates
+const ConfigPrefix = "/config/"
+
+// KeyReader reads keys of the registry for the template functions
+type KeyReader interface {
+	Get(ctx context.Context, key string) (*registry.Node, error)
+}
+
+// FuncMap is the map of functions, which are available in a template
+type FuncMap map[string]interface{}
+
+// Funcs returns the functions which are available in all templates. The registry functions read the keys below
+// /config from the reader, which should be the snapshot of the current rebuild. If the reader is nil, the registry
+// functions fail.
+func Funcs(ctx context.Context, reader KeyReader) FuncMap {
+	lookup := &registryLookup{ctx: ctx, reader: reader}
+	return FuncMap{
+		// nginx
+		"nginxQuote":  NginxQuote,
+		"nginxEscape": NginxEscape,
+
+		// strings, the string is the last parameter, so that the functions can be used in pipelines
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, value string) string { return strings.TrimPrefix(value, prefix) },
+		"trimSuffix": func(suffix, value string) string { return strings.TrimSuffix(value, suffix) },
+		"hasPrefix":  func(prefix, value string) bool { return strings.HasPrefix(value, prefix) },
+		"hasSuffix":  func(suffix, value string) bool { return strings.HasSuffix(value, suffix) },
+		"contains":   func(substring, value string) bool { return strings.Contains(value, substring) },
+		"replace":    func(old, new, value string) string { return strings.ReplaceAll(value, old, new) },
+		"split":      func(separator, value string) []string { return strings.Split(value, separator) },
+		"join":       join,
+
+		// values
+		"default":      defaultValue,
+		"toJson":       toJSON,
+		"toPrettyJson": toPrettyJSON,
+		"env":          os.Getenv,
+
+		// lists
+		"sortAlpha": sortAlpha,
+		"sortBy":    sortBy,
+		"where":     where,
+
+		// registry
+		"getv":   lookup.getv,
+		"exists": lookup.exists,
+		"ls":     lookup.ls,
+	}
+}
+
+// join joins the elements of a list with the separator, elements which are not strings are formatted with fmt
+func join(separator string, list interface{}) (string, error) {
+	items, err := toSlice(list)
+	if err != nil {
+		return "", err
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = fmt.Sprint(item)
+	}
+	return strings.Join(values, separator), nil
+}
+
+// defaultValue returns the value, if it is not empty, otherwise the fallback
+func defaultValue(fallback interface{}, value interface{}) interface{} {
+	if isEmpty(value) {
+		return fallback
+	}
+	return value
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return reflected.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return reflected.IsNil()
+	default:
+		return reflected.IsZero()
+	}
+}
+
+func toJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode value as json")
+	}
+	return string(data), nil
+}
+
+func toPrettyJSON(value interface{}) (string, error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode value as json")
+	}
+	return string(data), nil
+}
+
+// sortAlpha returns a sorted copy of the list, the elements are compared by their string representation
+func sortAlpha(list interface{}) ([]string, error) {
+	items, err := toSlice(list)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = fmt.Sprint(item)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// sortBy returns a copy of the list, which is sorted by the field, e.g. sortBy "Name" .Services. Elements with equal
+// fields keep their order.
+func sortBy(field string, list interface{}) ([]interface{}, error) {
+	items, err := toSlice(list)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(items))
+	for i, item := range items {
+		key, err := fieldValue(item, field)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	indices := make([]int, len(items))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return keys[indices[a]] < keys[indices[b]]
+	})
+
+	sorted := make([]interface{}, len(items))
+	for i, index := range indices {
+		sorted[i] = items[index]
+	}
+	return sorted, nil
+}
+
+// where returns the elements of the list, whose field equals the value, e.g. where "HealthStatus" "healthy" .Services
+// or where "Attributes.auth" "cas" .Services
+func where(field string, value string, list interface{}) ([]interface{}, error) {
+	items, err := toSlice(list)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []interface{}{}
+	for _, item := range items {
+		actual, err := fieldValue(item, field)
+		if err != nil {
+			return nil, err
+		}
+		if actual == value {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered, nil
+}
+
+func toSlice(list interface{}) ([]interface{}, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	reflected := reflect.ValueOf(list)
+	if reflected.Kind() != reflect.Slice && reflected.Kind() != reflect.Array {
+		return nil, errors.Errorf("expected a list, but got %T", list)
+	}
+
+	items := make([]interface{}, reflected.Len())
+	for i := range items {
+		items[i] = reflected.Index(i).Interface()
+	}
+	return items, nil
+}
+
+// fieldValue returns the string representation of the field of the item. The field is a dot separated path of struct
+// fields and map keys. Missing map keys and nil pointers result in an empty string.
+func fieldValue(item interface{}, field string) (string, error) {
+	current := reflect.ValueOf(item)
+	for _, name := range strings.Split(field, ".") {
+		for current.Kind() == reflect.Ptr || current.Kind() == reflect.Interface {
+			if current.IsNil() {
+				return "", nil
+			}
+			current = current.Elem()
+		}
+
+		switch current.Kind() {
+		case reflect.Struct:
+			current = current.FieldByName(name)
+			if !current.IsValid() {
+				return "", errors.Errorf("%s has no field %s", reflect.TypeOf(item), name)
+			}
+		case reflect.Map:
+			if current.Type().Key().Kind() != reflect.String {
+				return "", errors.Errorf("cannot read key %s of %s", name, current.Type())
+			}
+			current = current.MapIndex(reflect.ValueOf(name).Convert(current.Type().Key()))
+			if !current.IsValid() {
+				return "", nil
+			}
+		default:
+			return "", errors.Errorf("cannot read field %s of %s", name, current.Type())
+		}
+	}
+
+	for current.Kind() == reflect.Ptr || current.Kind() == reflect.Interface {
+		if current.IsNil() {
+			return "", nil
+		}
+		current = current.Elem()
+	}
+	if !current.CanInterface() {
+		return "", errors.Errorf("cannot read unexported field %s of %s", field, reflect.TypeOf(item))
+	}
+	return fmt.Sprint(current.Interface()), nil
+}
+
+// registryLookup implements the read-only registry functions of the templates
+type registryLookup struct {
+	ctx    context.Context
+	reader KeyReader
+}
+
+func (lookup *registryLookup) get(key string) (*registry.Node, error) {
+	if lookup.reader == nil {
+		return nil, errors.Errorf("failed to read %s, the registry is not available in this template", key)
+	}
+
+	key = path.Clean("/" + key)
+	if key+"/" != ConfigPrefix && !strings.HasPrefix(key, ConfigPrefix) {
+		return nil, errors.Errorf("failed to read %s, templates can only read keys below %s", key, ConfigPrefix)
+	}
+
+	node, err := lookup.reader.Get(lookup.ctx, key)
+	if registry.IsKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", key)
+	}
+	return node, nil
+}
+
+// getv returns the value of the key, e.g. getv "/config/nginx/buffering/cas" "on". If the key does not exist, the
+// optional default or an empty string is returned.
+func (lookup *registryLookup) getv(key string, fallback ...string) (string, error) {
+	node, err := lookup.get(key)
+	if err != nil {
+		return "", err
+	}
+	if node == nil || node.Dir {
+		if len(fallback) > 0 {
+			return fallback[0], nil
+		}
+		return "", nil
+	}
+	return node.Value, nil
+}
+
+// exists returns true, if the key exists
+func (lookup *registryLookup) exists(key string) (bool, error) {
+	node, err := lookup.get(key)
+	if err != nil {
+		return false, err
+	}
+	return node != nil, nil
+}
+
+// ls returns the sorted names of the children of the directory key
+func (lookup *registryLookup) ls(key string) ([]string, error) {
+	node, err := lookup.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	if node == nil {
+		return names, nil
+	}
+	for _, child := range node.Nodes {
+		names = append(names, child.Key[strings.LastIndex(child.Key, "/")+1:])
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,208 @@
+package template
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type service struct {
+	Name         string
+	HealthStatus string
+	Attributes   map[string]string
+}
+
+var services = []*service{
+	{Name: "redmine", HealthStatus: "healthy", Attributes: map[string]string{"auth": "cas"}},
+	{Name: "cas", HealthStatus: "unhealthy"},
+	{Name: "jenkins", HealthStatus: "healthy", Attributes: map[string]string{"auth": "cas"}},
+}
+
+func names(items []interface{}) []string {
+	result := []string{}
+	for _, item := range items {
+		result = append(result, item.(*service).Name)
+	}
+	return result
+}
+
+func TestFuncs_strings(t *testing.T) {
+	funcs := Funcs(context.Background(), nil)
+
+	assert.Equal(t, "cas", funcs["lower"].(func(string) string)("CAS"))
+	assert.Equal(t, "CAS", funcs["upper"].(func(string) string)("cas"))
+	assert.Equal(t, "cas", funcs["trim"].(func(string) string)(" cas\n"))
+	assert.Equal(t, "cas", funcs["trimPrefix"].(func(string, string) string)("/", "/cas"))
+	assert.Equal(t, "cas", funcs["trimSuffix"].(func(string, string) string)("/", "cas/"))
+	assert.True(t, funcs["hasPrefix"].(func(string, string) bool)("/c", "/cas"))
+	assert.True(t, funcs["hasSuffix"].(func(string, string) bool)("as", "/cas"))
+	assert.True(t, funcs["contains"].(func(string, string) bool)("a", "/cas"))
+	assert.Equal(t, "c-a-s", funcs["replace"].(func(string, string, string) string)("/", "-", "c/a/s"))
+	assert.Equal(t, []string{"c", "a", "s"}, funcs["split"].(func(string, string) []string)("/", "c/a/s"))
+
+	joined, err := join(",", []int{1, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, "1,2,3", joined)
+
+	_, err = join(",", "cas")
+	require.Error(t, err)
+}
+
+func Test_defaultValue(t *testing.T) {
+	assert.Equal(t, "on", defaultValue("on", ""))
+	assert.Equal(t, "on", defaultValue("on", nil))
+	assert.Equal(t, "on", defaultValue("on", []string{}))
+	assert.Equal(t, "on", defaultValue("on", 0))
+	assert.Equal(t, "off", defaultValue("on", "off"))
+	assert.Equal(t, 1, defaultValue(2, 1))
+	assert.Equal(t, false, defaultValue(false, (*service)(nil)))
+}
+
+func Test_toJSON(t *testing.T) {
+	value, err := toJSON(map[string]interface{}{"name": "cas", "ports": []int{8080}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"cas","ports":[8080]}`, value)
+
+	value, err = toPrettyJSON([]string{"cas"})
+	require.NoError(t, err)
+	assert.Equal(t, "[\n  \"cas\"\n]", value)
+
+	_, err = toJSON(func() {})
+	require.Error(t, err)
+}
+
+func Test_sortAlpha(t *testing.T) {
+	sorted, err := sortAlpha([]string{"redmine", "cas", "jenkins"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cas", "jenkins", "redmine"}, sorted)
+}
+
+func Test_sortBy(t *testing.T) {
+	t.Run("should sort by field", func(t *testing.T) {
+		sorted, err := sortBy("Name", services)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cas", "jenkins", "redmine"}, names(sorted))
+		assert.Equal(t, "redmine", services[0].Name, "the list must not be modified")
+	})
+
+	t.Run("should keep order of equal fields", func(t *testing.T) {
+		sorted, err := sortBy("HealthStatus", services)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"redmine", "jenkins", "cas"}, names(sorted))
+	})
+
+	t.Run("should sort by attribute", func(t *testing.T) {
+		sorted, err := sortBy("Attributes.auth", services)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cas", "redmine", "jenkins"}, names(sorted))
+	})
+
+	t.Run("should fail on unknown field", func(t *testing.T) {
+		_, err := sortBy("Port", services)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "has no field Port")
+	})
+}
+
+func Test_where(t *testing.T) {
+	t.Run("should filter by field", func(t *testing.T) {
+		filtered, err := where("HealthStatus", "healthy", services)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"redmine", "jenkins"}, names(filtered))
+	})
+
+	t.Run("should filter by attribute", func(t *testing.T) {
+		filtered, err := where("Attributes.auth", "cas", services)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"redmine", "jenkins"}, names(filtered))
+
+		filtered, err = where("Attributes.auth", "", services)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cas"}, names(filtered))
+	})
+
+	t.Run("should return empty list for nil", func(t *testing.T) {
+		filtered, err := where("Name", "cas", nil)
+		require.NoError(t, err)
+		assert.Empty(t, filtered)
+	})
+
+	t.Run("should fail on field of string", func(t *testing.T) {
+		_, err := where("Name.first", "cas", services)
+		require.Error(t, err)
+	})
+
+	t.Run("should fail on unexported field and map without string keys", func(t *testing.T) {
+		_, err := where("unexported", "cas", []struct{ unexported string }{{"cas"}})
+		require.Error(t, err)
+
+		_, err = where("Ports.http", "80", []struct{ Ports map[int]string }{{map[int]string{80: "http"}}})
+		require.Error(t, err)
+	})
+}
+
+func TestFuncs_registry(t *testing.T) {
+	memory := registry.NewMemoryRegistry()
+	require.NoError(t, memory.Set("/config/nginx/buffering/cas", "off"))
+	require.NoError(t, memory.Set("/config/nginx/buffering/redmine", "on"))
+	require.NoError(t, memory.Set("/services/cas/one", "{}"))
+	lookup := &registryLookup{ctx: context.Background(), reader: memory}
+
+	t.Run("should read value", func(t *testing.T) {
+		value, err := lookup.getv("/config/nginx/buffering/cas")
+		require.NoError(t, err)
+		assert.Equal(t, "off", value)
+
+		value, err = lookup.getv("/config/nginx/buffering/cas")
+		require.NoError(t, err)
+		assert.Equal(t, "off", value)
+	})
+
+	t.Run("should return default for missing key", func(t *testing.T) {
+		value, err := lookup.getv("/config/nginx/buffering/jenkins", "on")
+		require.NoError(t, err)
+		assert.Equal(t, "on", value)
+
+		value, err = lookup.getv("/config/nginx/buffering/jenkins")
+		require.NoError(t, err)
+		assert.Equal(t, "", value)
+	})
+
+	t.Run("should check existence", func(t *testing.T) {
+		exists, err := lookup.exists("/config/nginx/buffering/cas")
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = lookup.exists("/config/nginx/buffering/jenkins")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("should list children", func(t *testing.T) {
+		children, err := lookup.ls("/config/nginx/buffering")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cas", "redmine"}, children)
+
+		children, err = lookup.ls("/config/nginx/missing")
+		require.NoError(t, err)
+		assert.Empty(t, children)
+	})
+
+	t.Run("should not read keys outside of config", func(t *testing.T) {
+		_, err := lookup.getv("/services/cas/one")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "templates can only read keys below /config/")
+
+		_, err = lookup.getv("/config/../services/cas/one")
+		require.Error(t, err)
+	})
+
+	t.Run("should fail without registry", func(t *testing.T) {
+		_, err := (&registryLookup{ctx: context.Background()}).getv("/config/nginx/buffering/cas")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "registry is not available")
+	})
+}
@@ -0,0 +1,80 @@
+// Package template parses the templates of the generators with either text/template or html/template and provides
+// the library of functions, which are available in all templates.
+package template
+
+import (
+	"bytes"
+	htmlTemplate "html/template"
+	"io"
+	"path"
+	"strings"
+	textTemplate "text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Engine selects the template package which is used to render a template
+type Engine string
+
+const (
+	// EngineText renders the template with text/template, which writes values as they are, e.g. for nginx
+	// configurations
+	EngineText = Engine("text")
+	// EngineHTML renders the template with html/template, which escapes values according to their html context
+	EngineHTML = Engine("html")
+)
+
+// Template is a parsed text or html template
+type Template interface {
+	Execute(writer io.Writer, data interface{}) error
+}
+
+// ParseFile parses the template file with the engine, the functions are available in the template
+func ParseFile(engine Engine, file string, funcs FuncMap) (Template, error) {
+	name := path.Base(file)
+	switch engine {
+	case EngineText:
+		tmpl, err := textTemplate.New(name).Funcs(textTemplate.FuncMap(funcs)).ParseFiles(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse template")
+		}
+		return tmpl, nil
+	case EngineHTML:
+		tmpl, err := htmlTemplate.New(name).Funcs(htmlTemplate.FuncMap(funcs)).ParseFiles(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse template")
+		}
+		return tmpl, nil
+	default:
+		return nil, errors.Errorf("unknown template engine %s, use %s or %s", engine, EngineText, EngineHTML)
+	}
+}
+
+// Render parses the template file with the engine and executes it with the data
+func Render(engine Engine, file string, data interface{}, funcs FuncMap) ([]byte, error) {
+	tmpl, err := ParseFile(engine, file, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &bytes.Buffer{}
+	err = tmpl.Execute(buffer, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render template")
+	}
+	return buffer.Bytes(), nil
+}
+
+var nginxEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// NginxEscape escapes the value for a double-quoted nginx string. Nginx interprets backslashes and double quotes in
+// quoted strings, all other characters like spaces, semicolons or braces are taken literally. Variables like $host
+// are expanded by nginx in directives, which support variables.
+func NginxEscape(value string) string {
+	return nginxEscaper.Replace(value)
+}
+
+// NginxQuote escapes the value and wraps it in double quotes, so that it is a single nginx parameter
+func NginxQuote(value string) string {
+	return `"` + NginxEscape(value) + `"`
+}
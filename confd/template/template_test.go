@@ -0,0 +1,73 @@
+package template
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "app.conf.tpl")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func TestRender(t *testing.T) {
+	pattern := `^/cas/(.*)\?a=1&b="<2>"`
+	file := writeTemplate(t, "rewrite {{.}};")
+	funcs := Funcs(context.Background(), nil)
+
+	t.Run("should write values unchanged with text engine", func(t *testing.T) {
+		content, err := Render(EngineText, file, pattern, funcs)
+		require.NoError(t, err)
+		assert.Equal(t, `rewrite ^/cas/(.*)\?a=1&b="<2>";`, string(content))
+	})
+
+	t.Run("should escape values with html engine", func(t *testing.T) {
+		content, err := Render(EngineHTML, file, pattern, funcs)
+		require.NoError(t, err)
+		assert.Equal(t, `rewrite ^/cas/(.*)\?a=1&amp;b=&#34;&lt;2&gt;&#34;;`, string(content))
+	})
+
+	t.Run("should quote values for nginx", func(t *testing.T) {
+		file := writeTemplate(t, `rewrite {{nginxQuote .}}; set $x "{{nginxEscape .}}";`)
+		content, err := Render(EngineText, file, `a b;"c"\d`, funcs)
+		require.NoError(t, err)
+		assert.Equal(t, `rewrite "a b;\"c\"\\d"; set $x "a b;\"c\"\\d";`, string(content))
+	})
+
+	t.Run("should fail on unknown engine", func(t *testing.T) {
+		_, err := Render(Engine("jinja"), file, pattern, funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown template engine jinja")
+	})
+
+	t.Run("should fail on missing template", func(t *testing.T) {
+		_, err := Render(EngineText, filepath.Join(t.TempDir(), "missing.tpl"), pattern, funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to parse template")
+	})
+
+	t.Run("should fail on invalid template", func(t *testing.T) {
+		_, err := Render(EngineText, writeTemplate(t, "{{.Missing}"), pattern, funcs)
+		require.Error(t, err)
+	})
+
+	t.Run("should fail if the data does not match the template", func(t *testing.T) {
+		_, err := Render(EngineText, writeTemplate(t, "{{.Missing}}"), pattern, funcs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to render template")
+	})
+}
+
+func TestNginxQuote(t *testing.T) {
+	assert.Equal(t, `""`, NginxQuote(""))
+	assert.Equal(t, `"plain"`, NginxQuote("plain"))
+	assert.Equal(t, `"with space; and {braces}"`, NginxQuote("with space; and {braces}"))
+	assert.Equal(t, `"\"quoted\" \\ backslash"`, NginxQuote(`"quoted" \ backslash`))
+	assert.Equal(t, `"it's"`, NginxQuote("it's"))
+}
@@ -1,10 +1,11 @@
 package warp
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/cloudogu/ces-confd/confd/util"
-	"go.etcd.io/etcd/client/v2"
 	"log"
 	"strconv"
 
@@ -14,7 +15,7 @@ import (
 )
 
 type configRegistry interface {
-	Get(key string) (*client.Response, error)
+	Get(ctx context.Context, key string) (*confRegistry.Node, error)
 }
 
 // ConfigReader reads the configuration for the warp menu from etcd
@@ -60,15 +61,15 @@ func (reader *ConfigReader) createCategories(entries []EntryWithCategory) Catego
 
 // dogusReader reads from etcd and converts the keys and values to a warp menu
 // conform structure
-func (reader *ConfigReader) dogusReader(source Source) (Categories, error) {
+func (reader *ConfigReader) dogusReader(ctx context.Context, source Source) (Categories, error) {
 	log.Printf("read dogus from %s for warp menu", source.Path)
-	resp, err := reader.registry.Get(source.Path)
+	root, err := reader.registry.Get(ctx, source.Path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read root entry %s from etcd", source.Path)
 	}
 	dogus := []EntryWithCategory{}
-	for _, child := range resp.Node.Nodes {
-		dogu, err := readAndUnmarshalDogu(reader.registry, child.Key, source.Tag)
+	for _, child := range root.Nodes {
+		dogu, err := readAndUnmarshalDogu(ctx, reader.registry, child.Key, source.Tag)
 		if err != nil {
 			log.Printf("failed to read and unmarshal dogu: %v", err)
 		} else if dogu.Entry.Title != "" { // TODO more explicit way to handle filtered entries
@@ -79,15 +80,15 @@ func (reader *ConfigReader) dogusReader(source Source) (Categories, error) {
 	return reader.createCategories(dogus), nil
 }
 
-func (reader *ConfigReader) externalsReader(source Source) (Categories, error) {
+func (reader *ConfigReader) externalsReader(ctx context.Context, source Source) (Categories, error) {
 	log.Printf("read externals from %s for warp menu", source.Path)
-	resp, err := reader.registry.Get(source.Path)
+	root, err := reader.registry.Get(ctx, source.Path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read root entry %s from etcd", source.Path)
 	}
 	externals := []EntryWithCategory{}
-	for _, child := range resp.Node.Nodes {
-		external, err := readAndUnmarshalExternal(reader.registry, child.Key)
+	for _, child := range root.Nodes {
+		external, err := readAndUnmarshalExternal(ctx, reader.registry, child.Key)
 		if err != nil {
 			log.Printf("failed to read and unmarshal external: %v", err)
 		} else {
@@ -97,24 +98,24 @@ func (reader *ConfigReader) externalsReader(source Source) (Categories, error) {
 	return reader.createCategories(externals), nil
 }
 
-func (reader *ConfigReader) readSource(source Source) (Categories, error) {
+func (reader *ConfigReader) readSource(ctx context.Context, source Source) (Categories, error) {
 	switch source.SourceType {
 	case "dogus":
-		return reader.dogusReader(source)
+		return reader.dogusReader(ctx, source)
 	case "externals":
-		return reader.externalsReader(source)
+		return reader.externalsReader(ctx, source)
 	}
 	return nil, errors.Errorf("wrong source type: %v", source.SourceType)
 }
 
-func (reader *ConfigReader) readStrings(registryKey string) ([]string, error) {
-	entry, err := reader.registry.Get(registryKey)
+func (reader *ConfigReader) readStrings(ctx context.Context, registryKey string) ([]string, error) {
+	entry, err := reader.registry.Get(ctx, registryKey)
 	if err != nil {
 		return []string{}, fmt.Errorf("failed to read configuration entry %s from etcd: %w", registryKey, err)
 	}
 
 	var strings []string
-	err = json.Unmarshal([]byte(entry.Node.Value), &strings)
+	err = json.Unmarshal([]byte(entry.Value), &strings)
 	if err != nil {
 		return []string{}, fmt.Errorf("failed to unmarshal etcd key to string slice: %w", err)
 	}
@@ -122,13 +123,13 @@ func (reader *ConfigReader) readStrings(registryKey string) ([]string, error) {
 	return strings, nil
 }
 
-func (reader *ConfigReader) readBool(registryKey string) (bool, error) {
-	entry, err := reader.registry.Get(registryKey)
+func (reader *ConfigReader) readBool(ctx context.Context, registryKey string) (bool, error) {
+	entry, err := reader.registry.Get(ctx, registryKey)
 	if err != nil {
 		return false, fmt.Errorf("failed to read configuration entry %s from etcd: %w", registryKey, err)
 	}
 
-	boolValue, err := strconv.ParseBool(entry.Node.Value)
+	boolValue, err := strconv.ParseBool(entry.Value)
 	if err != nil {
 		return false, fmt.Errorf("failed to unmarshal etcd key to bool: %w", err)
 	}
@@ -155,11 +156,11 @@ func (reader *ConfigReader) readSupport(supportSources []SupportSource, blocked
 	return reader.createCategories(supportEntries)
 }
 
-func (reader *ConfigReader) readFromConfig(configuration Configuration) (Categories, error) {
+func (reader *ConfigReader) readFromConfig(ctx context.Context, configuration Configuration) (Categories, error) {
 	var data Categories
 
 	for _, source := range configuration.Sources {
-		categories, err := reader.readSource(source)
+		categories, err := reader.readSource(ctx, source)
 		if err != nil {
 			log.Println("Error during read:", err)
 		}
@@ -168,17 +169,17 @@ func (reader *ConfigReader) readFromConfig(configuration Configuration) (Categor
 
 	log.Println("read SupportEntries")
 
-	isSupportCategoryBlocked, err := reader.readBool(blockWarpSupportCategoryConfigurationKey)
+	isSupportCategoryBlocked, err := reader.readBool(ctx, blockWarpSupportCategoryConfigurationKey)
 	if err != nil {
 		log.Printf("Warning, could not read etcd Key: %v. Err: %v", blockWarpSupportCategoryConfigurationKey, err)
 	}
 
-	disabledSupportEntries, err := reader.readStrings(disabledWarpSupportEntriesConfigurationKey)
+	disabledSupportEntries, err := reader.readStrings(ctx, disabledWarpSupportEntriesConfigurationKey)
 	if err != nil {
 		log.Printf("Warning, could not read etcd Key: %v. Err: %v", disabledWarpSupportEntriesConfigurationKey, err)
 	}
 
-	allowedSupportEntries, err := reader.readStrings(allowedWarpSupportEntriesConfigurationKey)
+	allowedSupportEntries, err := reader.readStrings(ctx, allowedWarpSupportEntriesConfigurationKey)
 	if err != nil {
 		log.Printf("Warning, could not read etcd Key: %v. Err: %v", allowedWarpSupportEntriesConfigurationKey, err)
 	}
@@ -2,9 +2,11 @@ package warp
 
 import (
 	"bytes"
+	"context"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
-	"go.etcd.io/etcd/client/v2"
 	"log"
 	"os"
 	"testing"
@@ -105,18 +107,18 @@ func TestConfigReader_readSupport(t *testing.T) {
 func TestConfigReader_readFromConfig(t *testing.T) {
 	t.Run("should read categories from config", func(t *testing.T) {
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", blockWarpSupportCategoryConfigurationKey).
-			Return(&client.Response{Node: &client.Node{Value: "false"}}, nil)
-		mockRegistry.On("Get", disabledWarpSupportEntriesConfigurationKey).
-			Return(&client.Response{Node: &client.Node{Value: "[\"lorem\", \"ipsum\"]"}}, nil)
-		mockRegistry.On("Get", allowedWarpSupportEntriesConfigurationKey).
-			Return(&client.Response{Node: &client.Node{Value: "[\"lorem\", \"ipsum\"]"}}, nil)
-		mockRegistry.On("Get", "/config/externals").
-			Return(&client.Response{Node: &client.Node{Nodes: []*client.Node{
+		mockRegistry.On("Get", mock.Anything, blockWarpSupportCategoryConfigurationKey).
+			Return(&confRegistry.Node{Value: "false"}, nil)
+		mockRegistry.On("Get", mock.Anything, disabledWarpSupportEntriesConfigurationKey).
+			Return(&confRegistry.Node{Value: "[\"lorem\", \"ipsum\"]"}, nil)
+		mockRegistry.On("Get", mock.Anything, allowedWarpSupportEntriesConfigurationKey).
+			Return(&confRegistry.Node{Value: "[\"lorem\", \"ipsum\"]"}, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/externals").
+			Return(&confRegistry.Node{Nodes: confRegistry.Nodes{
 				{Key: "/config/externals/ext1"},
-			}}}, nil)
-		mockRegistry.On("Get", "/config/externals/ext1").
-			Return(&client.Response{Node: &client.Node{Value: "{\"DisplayName\": \"ext1\", \"URL\": \"https://my.url/ext1\", \"Description\": \"ext1 Description\", \"Category\": \"Documentation\"}"}}, nil)
+			}}, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/externals/ext1").
+			Return(&confRegistry.Node{Value: "{\"DisplayName\": \"ext1\", \"URL\": \"https://my.url/ext1\", \"Description\": \"ext1 Description\", \"Category\": \"Documentation\"}"}, nil)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
@@ -125,7 +127,7 @@ func TestConfigReader_readFromConfig(t *testing.T) {
 		testSources := []Source{{Path: "/config/externals", SourceType: "externals", Tag: "tag"}}
 		testSupportSoureces := []SupportSource{{Identifier: "supportSrc", External: true, Href: "https://support.source"}}
 
-		actual, err := reader.readFromConfig(Configuration{Sources: testSources, SupportSources: testSupportSoureces})
+		actual, err := reader.readFromConfig(context.Background(), Configuration{Sources: testSources, SupportSources: testSupportSoureces})
 		require.NoError(t, err)
 
 		expectedCategories := Categories{
@@ -141,10 +143,10 @@ func TestConfigReader_readFromConfig(t *testing.T) {
 
 	t.Run("should log errors when failing to read from registry client", func(t *testing.T) {
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", blockWarpSupportCategoryConfigurationKey).Return(nil, assert.AnError)
-		mockRegistry.On("Get", disabledWarpSupportEntriesConfigurationKey).Return(nil, assert.AnError)
-		mockRegistry.On("Get", allowedWarpSupportEntriesConfigurationKey).Return(nil, assert.AnError)
-		mockRegistry.On("Get", "/config/externals").Return(nil, assert.AnError)
+		mockRegistry.On("Get", mock.Anything, blockWarpSupportCategoryConfigurationKey).Return(nil, assert.AnError)
+		mockRegistry.On("Get", mock.Anything, disabledWarpSupportEntriesConfigurationKey).Return(nil, assert.AnError)
+		mockRegistry.On("Get", mock.Anything, allowedWarpSupportEntriesConfigurationKey).Return(nil, assert.AnError)
+		mockRegistry.On("Get", mock.Anything, "/config/externals").Return(nil, assert.AnError)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
@@ -160,7 +162,7 @@ func TestConfigReader_readFromConfig(t *testing.T) {
 			log.SetOutput(os.Stderr)
 		}()
 
-		actual, err := reader.readFromConfig(Configuration{Sources: testSources, SupportSources: testSupportSoureces})
+		actual, err := reader.readFromConfig(context.Background(), Configuration{Sources: testSources, SupportSources: testSupportSoureces})
 		require.NoError(t, err)
 
 		assert.Nil(t, actual)
@@ -176,28 +178,28 @@ func TestConfigReader_readFromConfig(t *testing.T) {
 
 func TestConfigReader_readStrings(t *testing.T) {
 	t.Run("should successfully read strings", func(t *testing.T) {
-		response := client.Response{Node: &client.Node{Value: "[\"lorem\", \"ipsum\"]"}}
+		node := confRegistry.Node{Value: "[\"lorem\", \"ipsum\"]"}
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/disabled_warpmenu_support_entries").Return(&response, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/disabled_warpmenu_support_entries").Return(&node, nil)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		identifiers, err := reader.readStrings("/config/_global/disabled_warpmenu_support_entries")
+		identifiers, err := reader.readStrings(context.Background(), "/config/_global/disabled_warpmenu_support_entries")
 		require.NoError(t, err)
 		assert.Equal(t, []string{"lorem", "ipsum"}, identifiers)
 	})
 
 	t.Run("should fail reading from registry", func(t *testing.T) {
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/disabled_warpmenu_support_entries").Return(nil, assert.AnError)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/disabled_warpmenu_support_entries").Return(nil, assert.AnError)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		identifiers, err := reader.readStrings("/config/_global/disabled_warpmenu_support_entries")
+		identifiers, err := reader.readStrings(context.Background(), "/config/_global/disabled_warpmenu_support_entries")
 		require.Error(t, err)
 		assert.ErrorIs(t, err, assert.AnError)
 		assert.ErrorContains(t, err, "failed to read configuration entry /config/_global/disabled_warpmenu_support_entries from etcd")
@@ -205,15 +207,15 @@ func TestConfigReader_readStrings(t *testing.T) {
 	})
 
 	t.Run("should fail unmarshalling", func(t *testing.T) {
-		response := client.Response{Node: &client.Node{Value: "not-a-string-array 123"}}
+		node := confRegistry.Node{Value: "not-a-string-array 123"}
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/disabled_warpmenu_support_entries").Return(&response, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/disabled_warpmenu_support_entries").Return(&node, nil)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		identifiers, err := reader.readStrings("/config/_global/disabled_warpmenu_support_entries")
+		identifiers, err := reader.readStrings(context.Background(), "/config/_global/disabled_warpmenu_support_entries")
 		require.Error(t, err)
 		assert.ErrorContains(t, err, "failed to unmarshal etcd key to string slice:")
 		assert.Equal(t, []string{}, identifiers)
@@ -222,42 +224,42 @@ func TestConfigReader_readStrings(t *testing.T) {
 
 func TestConfigReader_readBool(t *testing.T) {
 	t.Run("should successfully read true bool", func(t *testing.T) {
-		response := client.Response{Node: &client.Node{Value: "true"}}
+		node := confRegistry.Node{Value: "true"}
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/myBool").Return(&response, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/myBool").Return(&node, nil)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		boolValue, err := reader.readBool("/config/_global/myBool")
+		boolValue, err := reader.readBool(context.Background(), "/config/_global/myBool")
 		require.NoError(t, err)
 		assert.True(t, boolValue)
 	})
 
 	t.Run("should successfully read false bool", func(t *testing.T) {
-		response := client.Response{Node: &client.Node{Value: "false"}}
+		node := confRegistry.Node{Value: "false"}
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/myBool").Return(&response, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/myBool").Return(&node, nil)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		boolValue, err := reader.readBool("/config/_global/myBool")
+		boolValue, err := reader.readBool(context.Background(), "/config/_global/myBool")
 		require.NoError(t, err)
 		assert.False(t, boolValue)
 	})
 
 	t.Run("should fail reading from registry", func(t *testing.T) {
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/myBool").Return(nil, assert.AnError)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/myBool").Return(nil, assert.AnError)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		boolValue, err := reader.readBool("/config/_global/myBool")
+		boolValue, err := reader.readBool(context.Background(), "/config/_global/myBool")
 		require.Error(t, err)
 		assert.ErrorIs(t, err, assert.AnError)
 		assert.ErrorContains(t, err, "failed to read configuration entry /config/_global/myBool from etcd")
@@ -265,15 +267,15 @@ func TestConfigReader_readBool(t *testing.T) {
 	})
 
 	t.Run("should fail unmarshalling", func(t *testing.T) {
-		response := client.Response{Node: &client.Node{Value: "not a bool"}}
+		node := confRegistry.Node{Value: "not a bool"}
 		mockRegistry := newMockConfigRegistry(t)
-		mockRegistry.On("Get", "/config/_global/myBool").Return(&response, nil)
+		mockRegistry.On("Get", mock.Anything, "/config/_global/myBool").Return(&node, nil)
 
 		reader := &ConfigReader{
 			registry: mockRegistry,
 		}
 
-		boolValue, err := reader.readBool("/config/_global/myBool")
+		boolValue, err := reader.readBool(context.Background(), "/config/_global/myBool")
 		require.Error(t, err)
 		assert.ErrorContains(t, err, "failed to unmarshal etcd key to bool:")
 		assert.False(t, boolValue)
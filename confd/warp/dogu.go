@@ -1,11 +1,13 @@
 package warp
 
 import (
+	"context"
 	"encoding/json"
 
 	"strings"
 
 	"github.com/cloudogu/ces-confd/confd"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/pkg/errors"
 )
 
@@ -17,8 +19,8 @@ type doguEntry struct {
 	Tags        []string
 }
 
-func readAndUnmarshalDogu(registry configRegistry, key string, tag string) (EntryWithCategory, error) {
-	doguBytes, err := readDoguAsBytes(registry, key)
+func readAndUnmarshalDogu(ctx context.Context, registry configRegistry, key string, tag string) (EntryWithCategory, error) {
+	doguBytes, err := readDoguAsBytes(ctx, registry, key)
 	if err != nil {
 		return EntryWithCategory{}, err
 	}
@@ -36,23 +38,23 @@ func readAndUnmarshalDogu(registry configRegistry, key string, tag string) (Entr
 	return EntryWithCategory{}, nil
 }
 
-func readDoguAsBytes(registry configRegistry, key string) ([]byte, error) {
-	resp, err := registry.Get(key + "/current")
+func readDoguAsBytes(ctx context.Context, registry configRegistry, key string) ([]byte, error) {
+	node, err := registry.Get(ctx, key+"/current")
 	if err != nil {
 		// the dogu seems to be unregistered
-		if isKeyNotFound(err) {
+		if confRegistry.IsKeyNotFound(err) {
 			return nil, nil
 		}
 		return nil, errors.Wrapf(err, "failed to read key %s from etcd", key)
 	}
 
-	version := resp.Node.Value
-	resp, err = registry.Get(key + "/" + version)
+	version := node.Value
+	node, err = registry.Get(ctx, key+"/"+version)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read version child from key %s", key)
 	}
 
-	return []byte(resp.Node.Value), nil
+	return []byte(node.Value), nil
 }
 
 func unmarshalDogu(doguBytes []byte) (doguEntry, error) {
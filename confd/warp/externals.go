@@ -1,6 +1,7 @@
 package warp
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/pkg/errors"
@@ -13,8 +14,8 @@ type externalEntry struct {
 	Category    string
 }
 
-func readAndUnmarshalExternal(registry configRegistry, key string) (EntryWithCategory, error) {
-	externalBytes, err := readExternalAsBytes(registry, key)
+func readAndUnmarshalExternal(ctx context.Context, registry configRegistry, key string) (EntryWithCategory, error) {
+	externalBytes, err := readExternalAsBytes(ctx, registry, key)
 	if err != nil {
 		return EntryWithCategory{}, nil
 	}
@@ -22,13 +23,13 @@ func readAndUnmarshalExternal(registry configRegistry, key string) (EntryWithCat
 	return unmarshalExternal(externalBytes)
 }
 
-func readExternalAsBytes(registry configRegistry, key string) ([]byte, error) {
-	resp, err := registry.Get(key)
+func readExternalAsBytes(ctx context.Context, registry configRegistry, key string) ([]byte, error) {
+	node, err := registry.Get(ctx, key)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read key %s from etcd", key)
 	}
 
-	return []byte(resp.Node.Value), nil
+	return []byte(node.Value), nil
 }
 
 func unmarshalExternal(externalBytes []byte) (EntryWithCategory, error) {
@@ -3,8 +3,10 @@
 package warp
 
 import (
+	context "context"
+
+	registry "github.com/cloudogu/ces-confd/confd/registry"
 	mock "github.com/stretchr/testify/mock"
-	client "go.etcd.io/etcd/client/v2"
 )
 
 // mockConfigRegistry is an autogenerated mock type for the configRegistry type
@@ -20,29 +22,29 @@ func (_m *mockConfigRegistry) EXPECT() *mockConfigRegistry_Expecter {
 	return &mockConfigRegistry_Expecter{mock: &_m.Mock}
 }
 
-// Get provides a mock function with given fields: key
-func (_m *mockConfigRegistry) Get(key string) (*client.Response, error) {
-	ret := _m.Called(key)
+// Get provides a mock function with given fields: ctx, key
+func (_m *mockConfigRegistry) Get(ctx context.Context, key string) (*registry.Node, error) {
+	ret := _m.Called(ctx, key)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Get")
 	}
 
-	var r0 *client.Response
+	var r0 *registry.Node
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (*client.Response, error)); ok {
-		return rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*registry.Node, error)); ok {
+		return rf(ctx, key)
 	}
-	if rf, ok := ret.Get(0).(func(string) *client.Response); ok {
-		r0 = rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *registry.Node); ok {
+		r0 = rf(ctx, key)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*client.Response)
+			r0 = ret.Get(0).(*registry.Node)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(key)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -56,24 +58,25 @@ type mockConfigRegistry_Get_Call struct {
 }
 
 // Get is a helper method to define mock.On call
+//   - ctx context.Context
 //   - key string
-func (_e *mockConfigRegistry_Expecter) Get(key interface{}) *mockConfigRegistry_Get_Call {
-	return &mockConfigRegistry_Get_Call{Call: _e.mock.On("Get", key)}
+func (_e *mockConfigRegistry_Expecter) Get(ctx interface{}, key interface{}) *mockConfigRegistry_Get_Call {
+	return &mockConfigRegistry_Get_Call{Call: _e.mock.On("Get", ctx, key)}
 }
 
-func (_c *mockConfigRegistry_Get_Call) Run(run func(key string)) *mockConfigRegistry_Get_Call {
+func (_c *mockConfigRegistry_Get_Call) Run(run func(ctx context.Context, key string)) *mockConfigRegistry_Get_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string))
+		run(args[0].(context.Context), args[1].(string))
 	})
 	return _c
 }
 
-func (_c *mockConfigRegistry_Get_Call) Return(_a0 *client.Response, _a1 error) *mockConfigRegistry_Get_Call {
+func (_c *mockConfigRegistry_Get_Call) Return(_a0 *registry.Node, _a1 error) *mockConfigRegistry_Get_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *mockConfigRegistry_Get_Call) RunAndReturn(run func(string) (*client.Response, error)) *mockConfigRegistry_Get_Call {
+func (_c *mockConfigRegistry_Get_Call) RunAndReturn(run func(context.Context, string) (*registry.Node, error)) *mockConfigRegistry_Get_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -1,22 +1,51 @@
 package warp
 
 import (
+	"context"
 	"encoding/json"
-	"io/ioutil"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/cloudogu/ces-confd/confd"
-	"github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/output"
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/reload"
 	"github.com/pkg/errors"
-	"go.etcd.io/etcd/client/v2"
 )
 
 // Configuration for warp menu creation
 type Configuration struct {
-	Sources        []Source
-	Target         string
-	Order          confd.Order
-	SupportSources []SupportSource `yaml:"support"`
+	Sources            []Source
+	Target             string
+	Order              confd.Order
+	SupportSources     []SupportSource `yaml:"support"`
+	PreCommand         string          `yaml:"pre-command"`
+	PreCommandTimeout  time.Duration   `yaml:"pre-command-timeout"`
+	PostCommand        string          `yaml:"post-command"`
+	PostCommandTimeout time.Duration   `yaml:"post-command-timeout"`
+	QuarantineDir      string          `yaml:"quarantine-dir"`
+	Reload             reload.Reload
+	Debounce           confd.Debounce
+}
+
+// output returns the target of the warp menu with the pre- and post-commands of the configuration
+func (configuration Configuration) output() output.Output {
+	return output.Output{
+		Target:             configuration.Target,
+		PreCommand:         configuration.PreCommand,
+		PreCommandTimeout:  configuration.PreCommandTimeout,
+		PostCommand:        configuration.PostCommand,
+		PostCommandTimeout: configuration.PostCommandTimeout,
+		QuarantineDir:      configuration.QuarantineDir,
+		Reload:             configuration.Reload,
+		Mode:               0755,
+	}
+}
+
+// Validate checks the target of the configuration
+func (configuration Configuration) Validate() error {
+	return output.Validate([]output.Output{configuration.output()})
 }
 
 // Source in etcd
@@ -121,13 +150,6 @@ func (entries Entries) Swap(i, j int) {
 	entries[i], entries[j] = entries[j], entries[i]
 }
 
-func isKeyNotFound(err error) bool {
-	if cErr, ok := err.(client.Error); ok {
-		return cErr.Code == client.ErrorCodeKeyNotFound
-	}
-	return false
-}
-
 func (categories *Categories) insertCategories(newCategories Categories) {
 	for _, newCategory := range newCategories {
 		categories.insertCategory(newCategory)
@@ -144,51 +166,91 @@ func (categories *Categories) insertCategory(newCategory *Category) {
 	*categories = append(*categories, newCategory)
 }
 
-// JSONWriter converts the data to a json
-func jsonWriter(target string, data interface{}) error {
+// JSONWriter converts the data to a json and deploys it to the target with the pre- and post-commands, if it differs
+// from the current content
+func jsonWriter(target output.Output, data interface{}) error {
 	bytes, err := json.Marshal(data)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal data to json")
 	}
 
-	return ioutil.WriteFile(target, bytes, 0755)
+	return target.Deploy(bytes, output.Env(output.EnvGenerator, "warp"))
 }
 
-func execute(configuration Configuration, registry registry.Registry) {
+// MenuWriter deploys the warp menu of a generator. It serializes the deployments of the watchers, so that the
+// commands of a deployment do not overlap with another deployment of the menu.
+type MenuWriter struct {
+	target output.Output
+	mutex  sync.Mutex
+}
+
+// WriteMenu deploys the categories as json to the target
+func (w *MenuWriter) WriteMenu(categories Categories) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return jsonWriter(w.target, categories)
+}
+
+func execute(ctx context.Context, configuration Configuration, registry confRegistry.Registry, writer *MenuWriter) {
+	// all keys of a rebuild are read from the same state of the registry
 	reader := ConfigReader{
-		registry:      registry,
+		registry:      confRegistry.Snapshot(registry),
 		configuration: configuration,
 	}
-	categories, err := reader.readFromConfig(configuration)
+	categories, err := reader.readFromConfig(ctx, configuration)
 	if err != nil {
 		log.Println("Error during read:", err)
 		return
 	}
 	log.Printf("all found categories: %v", categories)
-	err = jsonWriter(configuration.Target, categories)
+	err = writer.WriteMenu(categories)
 	if err != nil {
 		log.Printf("failed to write warp menu as json: %v", err)
 	}
 }
 
-// Run creates the warp menu and update the menu whenever a relevant etcd key was changed
-func Run(configuration Configuration, registry registry.Registry) {
+// Run creates the warp menu and update the menu whenever a relevant etcd key was changed. Run returns after the
+// context is cancelled and all watchers have finished their work.
+func Run(ctx context.Context, configuration Configuration, registry confRegistry.Registry) {
+	writer := &MenuWriter{target: configuration.output()}
+	output.CheckPreCommands([]output.Output{writer.target})
 
 	log.Println("start watcher for warp entries")
-	warpChannel := make(chan *client.Response)
+	warpChannel := make(chan *confRegistry.Event)
 
+	var watchers sync.WaitGroup
 	for _, source := range configuration.Sources {
 
+		watchers.Add(1)
 		go func(source Source) {
-			for {
-				execute(configuration, registry)
-				registry.Watch(source.Path, true, warpChannel)
+			defer watchers.Done()
+			for ctx.Err() == nil {
+				execute(ctx, configuration, registry, writer)
+				registry.Watch(ctx, source.Path, true, warpChannel)
 			}
 		}(source)
 	}
 
-	for range warpChannel {
-		execute(configuration, registry)
+	debouncer := confd.NewDebouncer("warp", configuration.Debounce)
+	defer debouncer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			watchers.Wait()
+			log.Println("stopped watcher for warp entries")
+			return
+		case event := <-warpChannel:
+			// every event leads to a full rebuild, so a resync needs no special treatment
+			if event.IsResync() {
+				log.Printf("resync warp menu, changes of %s may have been lost", event.Key())
+			}
+			if debouncer.Trigger() {
+				execute(ctx, configuration, registry, writer)
+			}
+		case <-debouncer.C():
+			log.Printf("rebuild warp menu after %d changes", debouncer.Flush())
+			execute(ctx, configuration, registry, writer)
+		}
 	}
 
 }
@@ -1,10 +1,17 @@
 package warp_test
 
 import (
+	"context"
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
+	confRegistry "github.com/cloudogu/ces-confd/confd/registry"
 	"github.com/cloudogu/ces-confd/confd/warp"
+	"github.com/stretchr/testify/require"
   "github.com/stretchr/testify/assert"
   "fmt"
 )
@@ -42,3 +49,113 @@ func marshal(t *testing.T, target warp.Target) string {
 type targetStruct struct {
 	Target warp.Target
 }
+
+func TestRun(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "menu.json")
+	registry := confRegistry.NewMemoryRegistry()
+	require.NoError(t, registry.Set("/dogu/cas/current", "1.0.0"))
+	require.NoError(t, registry.Set("/dogu/cas/1.0.0", `{"Name": "official/cas", "DisplayName": "CAS", "Description": "Login", "Category": "Administration", "Tags": ["warp"]}`))
+	configuration := warp.Configuration{
+		Sources: []warp.Source{{Path: "/dogu", SourceType: "dogus", Tag: "warp"}},
+		Target:  target,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		warp.Run(ctx, configuration, registry)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	requireMenu(t, target, map[string][]string{"Administration": {"CAS"}})
+
+	require.NoError(t, registry.Set("/dogu/scm/current", "2.0.0"))
+	require.NoError(t, registry.Set("/dogu/scm/2.0.0", `{"Name": "official/scm", "DisplayName": "SCM-Manager", "Description": "Repositories", "Category": "Development Apps", "Tags": ["warp"]}`))
+	requireMenu(t, target, map[string][]string{"Administration": {"CAS"}, "Development Apps": {"SCM-Manager"}})
+
+	require.NoError(t, registry.Delete("/dogu/cas", true))
+	requireMenu(t, target, map[string][]string{"Development Apps": {"SCM-Manager"}})
+}
+
+// requireMenu waits until the menu contains exactly the entries of the categories
+func requireMenu(t *testing.T, target string, expected map[string][]string) {
+	var actual map[string][]string
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(target)
+		if err != nil {
+			return false
+		}
+
+		var categories []struct {
+			Title   string
+			Entries []struct{ DisplayName string }
+		}
+		if json.Unmarshal(content, &categories) != nil {
+			return false
+		}
+
+		actual = map[string][]string{}
+		for _, category := range categories {
+			for _, entry := range category.Entries {
+				actual[category.Title] = append(actual[category.Title], entry.DisplayName)
+			}
+		}
+		return reflect.DeepEqual(expected, actual)
+	}, 5*time.Second, 10*time.Millisecond, "menu does not contain %v", expected)
+}
+
+func TestRun_hooks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "menu.json")
+	commands := filepath.Join(dir, "commands")
+	quarantine := filepath.Join(dir, "quarantine")
+
+	registry := confRegistry.NewMemoryRegistry()
+	require.NoError(t, registry.Set("/dogu/cas/current", "1.0.0"))
+	require.NoError(t, registry.Set("/dogu/cas/1.0.0", `{"Name": "official/cas", "DisplayName": "CAS", "Description": "Login", "Category": "Administration", "Tags": ["warp"]}`))
+	configuration := warp.Configuration{
+		Sources: []warp.Source{{Path: "/dogu", SourceType: "dogus", Tag: "warp"}},
+		Target:  target,
+		// the menu must always contain the login
+		PreCommand:    `grep -q '"CAS"' "$CES_CONFD_CANDIDATE"`,
+		PostCommand:   `echo "$CES_CONFD_GENERATOR" >> ` + commands,
+		QuarantineDir: quarantine,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		warp.Run(ctx, configuration, registry)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	requireMenu(t, target, map[string][]string{"Administration": {"CAS"}})
+
+	require.NoError(t, registry.Set("/dogu/scm/current", "2.0.0"))
+	require.NoError(t, registry.Set("/dogu/scm/2.0.0", `{"Name": "official/scm", "DisplayName": "SCM-Manager", "Description": "Repositories", "Category": "Development Apps", "Tags": ["warp"]}`))
+	requireMenu(t, target, map[string][]string{"Administration": {"CAS"}, "Development Apps": {"SCM-Manager"}})
+
+	// the menu without login is rejected by the pre-command
+	require.NoError(t, registry.Delete("/dogu/cas", true))
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(quarantine)
+		return err == nil && len(entries) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	requireMenu(t, target, map[string][]string{"Administration": {"CAS"}, "Development Apps": {"SCM-Manager"}})
+
+	content, err := os.ReadFile(commands)
+	require.NoError(t, err)
+	assert.Equal(t, "warp\nwarp\n", string(content))
+
+	info, err := os.Stat(target)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"gopkg.in/yaml.v2"
 
@@ -16,7 +21,6 @@ import (
 	"github.com/cloudogu/ces-confd/confd/warp"
 	"github.com/codegangsta/cli"
 	"github.com/pkg/errors"
-	"go.etcd.io/etcd/client/v2"
 )
 
 var (
@@ -24,12 +28,59 @@ var (
 	Version string
 )
 
+const (
+	defaultEndpoint       = "http://localhost:2379"
+	defaultConsulEndpoint = "http://localhost:8500"
+)
+
 // Configuration main configuration object
 type Configuration struct {
-	Endpoint    string
-	Warp        warp.Configuration
-	Service     service.Configuration
-	Maintenance maintenance.Configuration
+	// Endpoint is the single etcd endpoint of older configurations, it is only used if no endpoints are configured
+	Endpoint         string
+	Endpoints        []string
+	Backend          string
+	Retry            registry.RetryPolicy
+	TLS              registry.TLSConfig
+	Username         string
+	Password         string
+	DialTimeout      time.Duration `yaml:"dial-timeout"`
+	HeaderTimeout    time.Duration `yaml:"header-timeout"`
+	AutoSyncInterval time.Duration `yaml:"auto-sync-interval"`
+	Directory        string
+	Token            string
+	Cache            bool
+	MetricsAddress   string `yaml:"metrics-address"`
+	Warp             warp.Configuration
+	Service          service.Configuration
+	Maintenance      maintenance.Configuration
+}
+
+// endpoints returns the configured endpoints or the default endpoint of the backend, if no endpoint is configured
+func (config *Configuration) endpoints() []string {
+	if len(config.Endpoints) > 0 {
+		return config.Endpoints
+	}
+	if config.Endpoint != "" {
+		return []string{config.Endpoint}
+	}
+	if config.Backend == registry.BackendConsul {
+		return []string{defaultConsulEndpoint}
+	}
+	return []string{defaultEndpoint}
+}
+
+// splitEndpoints accepts repeated and comma separated endpoints
+func splitEndpoints(values []string) []string {
+	var endpoints []string
+	for _, value := range values {
+		for _, endpoint := range strings.Split(value, ",") {
+			endpoint = strings.TrimSpace(endpoint)
+			if endpoint != "" {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	}
+	return endpoints
 }
 
 // Application struct
@@ -37,28 +88,37 @@ type Application struct {
 	Configuration *Configuration
 }
 
-func (app *Application) createEtcdClient() (client.KeysAPI, error) {
-	cfg := client.Config{
-		Endpoints: []string{app.Configuration.Endpoint},
-		Transport: client.DefaultTransport,
-		// set timeout per request to fail fast when the target endpoint is unavailable
-		HeaderTimeoutPerRequest: time.Second,
-	}
-
-	ec, err := client.New(cfg)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create etcd client")
+// createRegistry creates the registry of the configured backend
+func (app *Application) createRegistry(ctx context.Context) (registry.Registry, error) {
+	cfg := registry.Config{
+		Backend:          app.Configuration.Backend,
+		Endpoints:        app.Configuration.endpoints(),
+		Retry:            app.Configuration.Retry,
+		TLS:              app.Configuration.TLS,
+		Username:         app.Configuration.Username,
+		Password:         app.Configuration.Password,
+		DialTimeout:      app.Configuration.DialTimeout,
+		HeaderTimeout:    app.Configuration.HeaderTimeout,
+		AutoSyncInterval: app.Configuration.AutoSyncInterval,
+		Directory:        app.Configuration.Directory,
+		Token:            app.Configuration.Token,
 	}
 
-	return client.NewKeysAPI(ec), nil
+	return registry.New(ctx, cfg)
 }
 
-func (app *Application) createEtcdRegistry() (registry.Registry, error) {
-	cfg := registry.Config{
-		Endpoints: []string{app.Configuration.Endpoint},
+// watchedKeys returns the keys which are watched by the generators
+func (app *Application) watchedKeys() []string {
+	keys := []string{
+		app.Configuration.Maintenance.Source.Path,
+		app.Configuration.Service.Source.Path,
+		app.Configuration.Service.MaintenanceMode,
+		service.BufferingPath,
 	}
-
-	return registry.NewEtcdRegistry(cfg)
+	for _, source := range app.Configuration.Warp.Sources {
+		keys = append(keys, source.Path)
+	}
+	return keys
 }
 
 func (app *Application) readConfiguration(path string) error {
@@ -75,46 +135,160 @@ func (app *Application) readConfiguration(path string) error {
 	return nil
 }
 
-func (app *Application) run(c *cli.Context) {
-	err := app.readConfiguration(c.String("config"))
+// serveMetrics exposes the counters of ces-confd in the expvar format at /debug/vars
+func (app *Application) serveMetrics() {
+	log.Printf("serve metrics on %s/debug/vars", app.Configuration.MetricsAddress)
+	err := http.ListenAndServe(app.Configuration.MetricsAddress, nil)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("failed to serve metrics: %v", err)
 	}
+}
 
-	r1, err := app.createEtcdRegistry()
+// openReplay reads a recording of the --record flag
+func openReplay(path string) (*registry.ReplayRegistry, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, errors.Wrap(err, "could not open recording "+path)
 	}
+	defer file.Close()
+
+	log.Printf("replay recording %s", path)
+	return registry.NewReplayRegistry(file)
+}
 
-	r2, err := app.createEtcdRegistry()
+// configure reads the configuration file and applies the flags of the command line, which take precedence over the
+// values of the file
+func (app *Application) configure(c *cli.Context) error {
+	err := app.readConfiguration(c.String("config"))
+	if err != nil {
+		return err
+	}
+
+	// endpoints of the command line replace the endpoints of the configuration
+	if c.IsSet("endpoint") {
+		app.Configuration.Endpoints = splitEndpoints(c.StringSlice("endpoint"))
+	}
+	if c.IsSet("backend") {
+		app.Configuration.Backend = c.String("backend")
+	}
+	if c.IsSet("directory") {
+		app.Configuration.Directory = c.String("directory")
+	}
+	if app.Configuration.Backend == "" {
+		app.Configuration.Backend = registry.BackendEtcd
+	}
+	return app.Configuration.validate()
+}
+
+// validate checks the configurations of the generators, before any target is written
+func (config *Configuration) validate() error {
+	if err := config.Warp.Validate(); err != nil {
+		return errors.Wrap(err, "invalid warp configuration")
+	}
+	if err := config.Service.Validate(); err != nil {
+		return errors.Wrap(err, "invalid service configuration")
+	}
+	if err := config.Maintenance.Validate(); err != nil {
+		return errors.Wrap(err, "invalid maintenance configuration")
+	}
+	return nil
+}
+
+func (app *Application) run(c *cli.Context) {
+	err := app.configure(c)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	r3, err := app.createEtcdRegistry()
+	if app.Configuration.MetricsAddress != "" {
+		go app.serveMetrics()
+	}
+
+	// cancel all watchers on shutdown, the generators finish their current write before they return
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var r registry.Registry
+	var replay *registry.ReplayRegistry
+	if path := c.String("replay"); path != "" {
+		replay, err = openReplay(path)
+		r = replay
+	} else {
+		r, err = app.createRegistry(ctx)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if path := c.String("record"); path != "" {
+		recording, err := os.Create(path)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "could not create recording "+path))
+		}
+		defer recording.Close()
+
+		log.Printf("record registry to %s", path)
+		r = registry.NewRecordingRegistry(r, recording)
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Println("received shutdown signal, stopping watchers")
+	}()
+
+	// a replay stops the generators after the last recorded event
+	if replay != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		go func() {
+			replay.Wait(ctx)
+			log.Println("replay finished, stopping watchers")
+			cancel()
+		}()
+	}
+
 	var syncWaitGroup sync.WaitGroup
 
+	// the generators read the watched keys from an in-memory mirror
+	if app.Configuration.Cache {
+		cache := registry.NewCachingRegistry(r, app.Configuration.Retry, app.watchedKeys()...)
+		r = cache
+
+		syncWaitGroup.Add(1)
+		go func() {
+			cache.Run(ctx)
+			syncWaitGroup.Done()
+		}()
+	}
+
+	// all generators share the connection and the watches of a single registry
+	hub := registry.NewWatchHub(r, app.watchedKeys()...)
+
+	syncWaitGroup.Add(1)
+	go func() {
+		hub.Run(ctx)
+		syncWaitGroup.Done()
+	}()
 	syncWaitGroup.Add(1)
 	go func() {
-		maintenance.Run(app.Configuration.Maintenance, r1)
+		maintenance.Run(ctx, app.Configuration.Maintenance, hub)
 		syncWaitGroup.Done()
 	}()
 	syncWaitGroup.Add(1)
 	go func() {
-		warp.Run(app.Configuration.Warp, r2)
+		warp.Run(ctx, app.Configuration.Warp, hub)
 		syncWaitGroup.Done()
 	}()
 	syncWaitGroup.Add(1)
 	go func() {
-		service.Run(app.Configuration.Service, r3)
+		service.Run(ctx, app.Configuration.Service, hub)
 		syncWaitGroup.Done()
 	}()
 
 	syncWaitGroup.Wait()
+	log.Println("all watchers stopped")
 }
 
 func main() {
@@ -128,12 +302,33 @@ func main() {
 	app.Version = Version
 	app.Usage = "watches etcd for changes and writes config files"
 	app.Action = application.run
-	app.Flags = []cli.Flag{
+	app.Flags = flags()
+
+	app.Run(os.Args)
+}
+
+// flags returns the flags of the command line
+func flags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "endpoint, e",
+			Usage: "registry endpoint, can be repeated or comma separated (default: " + defaultEndpoint + ", consul: " + defaultConsulEndpoint + ")",
+		},
+		cli.StringFlag{
+			Name:  "backend, b",
+			Usage: "registry backend (etcd, etcd3, consul or file), replaces the backend of the configuration (default: " + registry.BackendEtcd + ")",
+		},
+		cli.StringFlag{
+			Name:  "directory, d",
+			Usage: "directory with the keys of the file backend, replaces the directory of the configuration",
+		},
 		cli.StringFlag{
-			Name:        "endpoint, e",
-			Value:       "http://localhost:2379",
-			Usage:       "etcd endpoint",
-			Destination: &config.Endpoint,
+			Name:  "record",
+			Usage: "record all reads and events of the registry as json lines to the file",
+		},
+		cli.StringFlag{
+			Name:  "replay",
+			Usage: "replay a recording of --record instead of reading the registry, stops after the last event",
 		},
 		cli.StringFlag{
 			Name:  "config, c",
@@ -141,6 +336,4 @@ func main() {
 			Usage: "configuration path",
 		},
 	}
-
-	app.Run(os.Args)
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudogu/ces-confd/confd/registry"
+	"github.com/cloudogu/ces-confd/confd/service"
+	"github.com/codegangsta/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// configure parses the arguments with the flags of ces-confd and applies them to the configuration file
+func configure(t *testing.T, configuration string, args ...string) *Configuration {
+	config, err := tryConfigure(t, configuration, args...)
+	require.NoError(t, err)
+	return config
+}
+
+func tryConfigure(t *testing.T, configuration string, args ...string) (*Configuration, error) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(configuration), 0644))
+
+	config := &Configuration{}
+	application := Application{Configuration: config}
+
+	var configureErr error
+	app := cli.NewApp()
+	app.Flags = flags()
+	app.Action = func(c *cli.Context) {
+		configureErr = application.configure(c)
+	}
+	require.NoError(t, app.Run(append([]string{"ces-confd", "--config", path}, args...)))
+	return config, configureErr
+}
+
+func TestApplication_configure(t *testing.T) {
+	t.Run("should prefer backend flag over configuration", func(t *testing.T) {
+		config := configure(t, "backend: etcd\n", "--backend", "file")
+		assert.Equal(t, registry.BackendFile, config.Backend)
+	})
+
+	t.Run("should prefer directory flag over configuration", func(t *testing.T) {
+		config := configure(t, "backend: file\ndirectory: /var/lib/registry\n", "--directory", "/tmp/fixtures")
+		assert.Equal(t, registry.BackendFile, config.Backend)
+		assert.Equal(t, "/tmp/fixtures", config.Directory)
+	})
+
+	t.Run("should use directory of configuration without flag", func(t *testing.T) {
+		config := configure(t, "directory: /var/lib/registry\n", "--backend", "file")
+		assert.Equal(t, "/var/lib/registry", config.Directory)
+	})
+
+	t.Run("should use backend of configuration without flag", func(t *testing.T) {
+		config := configure(t, "backend: consul\n")
+		assert.Equal(t, registry.BackendConsul, config.Backend)
+	})
+
+	t.Run("should default to etcd", func(t *testing.T) {
+		config := configure(t, "metrics-address: localhost:9090\n")
+		assert.Equal(t, registry.BackendEtcd, config.Backend)
+	})
+
+	t.Run("should prefer endpoint flags over configuration", func(t *testing.T) {
+		config := configure(t, "endpoints: [http://etcd:2379]\n", "--endpoint", "http://one:2379,http://two:2379")
+		assert.Equal(t, []string{"http://one:2379", "http://two:2379"}, config.Endpoints)
+	})
+}
+
+func TestApplication_configure_validate(t *testing.T) {
+	t.Run("should reject invalid reload signal", func(t *testing.T) {
+		_, err := tryConfigure(t, "service:\n  target: app.conf\n  reload:\n    pid-file: /run/nginx.pid\n    signal: KILL\n")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid service configuration")
+		assert.Contains(t, err.Error(), "unsupported reload signal KILL")
+	})
+
+	t.Run("should reject invalid reload signal of warp menu", func(t *testing.T) {
+		_, err := tryConfigure(t, "warp:\n  target: menu.json\n  reload:\n    pid-file: /run/nginx.pid\n    signal: TERM\n")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid warp configuration")
+	})
+}
+
+func TestConfiguration_endpoints(t *testing.T) {
+	sample, err := os.ReadFile(filepath.Join("resources", "config.yaml"))
+	require.NoError(t, err)
+
+	t.Run("should use default endpoint of the backend of the sample", func(t *testing.T) {
+		config := configure(t, string(sample), "--backend", "consul")
+		assert.Equal(t, []string{defaultConsulEndpoint}, config.endpoints())
+
+		config = configure(t, string(sample))
+		assert.Equal(t, []string{defaultEndpoint}, config.endpoints())
+	})
+}
+
+func TestApplication_watchedKeys(t *testing.T) {
+	config := configure(t, "service:\n  source:\n    path: /services\n  maintenance-mode: /config/_global/maintenance\n")
+	application := Application{Configuration: config}
+
+	keys := application.watchedKeys()
+	assert.Contains(t, keys, "/services")
+	assert.Contains(t, keys, "/config/_global/maintenance")
+	assert.Contains(t, keys, service.BufferingPath)
+}
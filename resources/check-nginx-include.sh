@@ -0,0 +1,31 @@
+#!/bin/sh
+# Checks a rendered nginx include, e.g. app.conf, before ces-confd replaces the target:
+#
+#   pre-command: /etc/ces-confd/check-nginx-include.sh "$CES_CONFD_CANDIDATE" "$CES_CONFD_TARGET"
+#
+# nginx -t only checks a complete main configuration, which includes the live target and not the candidate. The
+# script mirrors the directory of the target into a temporary directory, with the candidate in place of the target,
+# and checks a copy of the main configuration, whose includes of the target directory point to the mirror. The main
+# configuration (NGINX_CONF, default /etc/nginx/nginx.conf) has to include the target directory with an absolute path,
+# e.g. include /etc/nginx/conf.d/*.conf;
+set -eu
+
+candidate="${1:-${CES_CONFD_CANDIDATE:?candidate is missing}}"
+target="${2:-${CES_CONFD_TARGET:?target is missing}}"
+main="${NGINX_CONF:-/etc/nginx/nginx.conf}"
+directory="$(dirname "${target}")"
+
+mirror="$(mktemp -d)"
+trap 'rm -rf "${mirror}"' EXIT
+
+# hidden files, like other candidates and the last-known-good, are not matched and not included by nginx either
+for file in "${directory}"/*; do
+  if [ -e "${file}" ]; then
+    ln -s "${file}" "${mirror}/$(basename "${file}")"
+  fi
+done
+rm -f "${mirror}/$(basename "${target}")"
+cp "${candidate}" "${mirror}/$(basename "${target}")"
+
+sed "s#${directory}/#${mirror}/#g" "${main}" > "${mirror}/.nginx.conf"
+nginx -t -q -c "${mirror}/.nginx.conf"